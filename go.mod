@@ -8,6 +8,7 @@ require (
 	github.com/paulmach/go.geojson v1.5.0
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/exp v0.0.0-20240314144324-c7f7c6466f7f
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -7,9 +7,13 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/mail"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
@@ -100,6 +104,131 @@ func parseCoords(s string) ([][2]float64, error) {
 	return ret, nil
 }
 
+func parseReplacements(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]string, len(records))
+	for _, rec := range records {
+		ret[strings.TrimSpace(rec[0])] = strings.TrimSpace(rec[1])
+	}
+
+	return ret, nil
+}
+
+func parseWordList(path string) ([]string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0)
+	for _, line := range strings.Split(string(bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+
+	return ret, nil
+}
+
+func parseClusterOverrides(path string) ([][2]string, [][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	force := make([][2]string, 0)
+	never := make([][2]string, 0)
+
+	for _, rec := range records {
+		pair := [2]string{strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])}
+		switch strings.ToLower(strings.TrimSpace(rec[2])) {
+		case "force":
+			force = append(force, pair)
+		case "never":
+			never = append(never, pair)
+		default:
+			return nil, nil, fmt.Errorf("unknown override '%s' for stops (%s, %s), expected 'force' or 'never'", rec[2], pair[0], pair[1])
+		}
+	}
+
+	return force, never, nil
+}
+
+// fares v2 files, which gtfsparser does not read and gtfswriter does not
+// write, so they cannot be carried through to the output
+var faresV2Files = []string{"fare_media.txt", "fare_products.txt", "fare_leg_rules.txt", "fare_leg_join_rules.txt", "fare_transfer_rules.txt", "areas.txt", "stop_areas.txt", "networks.txt", "route_networks.txt", "timeframes.txt", "rider_categories.txt"}
+
+// translations.txt is modelled by gtfsparser, but gtfswriter cannot write it,
+// so translations are lost in the output
+var translationFiles = []string{"translations.txt"}
+
+// GTFS-Flex files, which gtfsparser does not read and gtfswriter does not
+// write. gtfsparser also requires stop_id in stop_times.txt, so Flex stop
+// times referencing a location_id or location_group_id fail to parse.
+var flexFiles = []string{"locations.geojson", "location_groups.txt", "location_group_stops.txt", "booking_rules.txt"}
+
+// Return the files from list which are present in the GTFS feed at path,
+// which may either be a directory or a ZIP file
+func getPresentFiles(path string, list []string) []string {
+	names := make(map[string]bool)
+
+	if st, err := os.Stat(path); err == nil && st.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+		for _, e := range entries {
+			names[e.Name()] = true
+		}
+	} else {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			names[f.FileInfo().Name()] = true
+		}
+	}
+
+	ret := make([]string, 0)
+	for _, name := range list {
+		if names[name] {
+			ret = append(ret, name)
+		}
+	}
+
+	return ret
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "gtfstidy - (C) 2016-2026 by Patrick Brosi <info@patrickbrosi.de>. Contributions by Patrick Steil, Davids Paskevics, and others.\n\nUsage:\n\n  %s [<options>] [-o <outputfile>] <input GTFS>\n\nAllowed options:\n\n", os.Args[0])
@@ -120,9 +249,9 @@ func main() {
 	endDateFilter := flag.StringP("date-end", "", "", "end date filter, as YYYYMMDD")
 
 	fixShortHand := flag.BoolP("fix", "", false, "shorthand for -eDnz -p '-'")
-	compressShortHand := flag.BoolP("compress", "", false, "shorthand for -OSRCcIAP")
-	minimizeShortHand := flag.BoolP("Compress", "", false, "shorthand for -OSRCcIAPdT --red-stops-fuzzy --red-trips-fuzzy, like --compress, but additionally compress stop times into frequencies, use fuzzy matching for redundant trip and stop removal and use dense character ids. The latter destroys any existing external references (like in GTFS realtime streams)")
-	mergeShortHand := flag.BoolP("merge", "", false, "shorthand for -ARPICO")
+	compressShortHand := flag.BoolP("compress", "", false, "shorthand for -OSRCcIAP --remove-red-fares")
+	minimizeShortHand := flag.BoolP("Compress", "", false, "shorthand for -OSRCcIAPdT --remove-red-fares --red-stops-fuzzy --red-trips-fuzzy, like --compress, but additionally compress stop times into frequencies, use fuzzy matching for redundant trip and stop removal and use dense character ids. The latter destroys any existing external references (like in GTFS realtime streams)")
+	mergeShortHand := flag.BoolP("merge", "", false, "shorthand for -ARPICO --remove-red-fares")
 	fuzzyMergeShortHand := flag.BoolP("Merge", "", false, "shorthand for -EARPICO --red-trips-fuzzy --red-stops-fuzzy")
 
 	assumeCleanCsv := flag.BoolP("assume-clean-csv", "", false, "assume clean csv (no leading spaces, clean line breaks)")
@@ -141,7 +270,7 @@ func main() {
 
 	idPrefix := flag.StringP("prefix", "", "", "prefix used before all ids")
 
-	keepIds := flag.BoolP("keep-ids", "", false, "preserve station, fare, shape, route, trip, level, agency, pathway, and service IDs")
+	keepIds := flag.BoolP("keep-ids", "", false, "preserve station, fare, zone, shape, route, trip, level, agency, pathway, and service IDs")
 	keepStationIds := flag.BoolP("keep-station-ids", "", false, "preserve station IDs")
 	keepStationIFTOPTIds := flag.BoolP("keep-station-ifopt-ids", "", false, "don't remove duplicate stops if they have different IFTOP ids")
 	keepBlockIds := flag.BoolP("keep-block-ids", "", false, "preserve block IDs")
@@ -154,25 +283,74 @@ func main() {
 	keepAttributionIds := flag.BoolP("keep-attribution-ids", "", false, "preserve attribution IDs")
 	keepServiceIds := flag.BoolP("keep-service-ids", "", false, "preserve service IDs in calendar.txt and calendar_dates.txt")
 	keepAgencyIds := flag.BoolP("keep-agency-ids", "", false, "preserve agency IDs")
-	orphanDeleters := flag.StringSliceP("delete-orphans", "O", []string{}, "remove entities that are not referenced anywhere\ncomma-separated list of supported files:\nall,agency,routes,services,shapes,stops,transfers,trips")
+	keepZoneIds := flag.BoolP("keep-zone-ids", "", false, "preserve fare zone IDs")
+	orphanDeleters := flag.StringSliceP("delete-orphans", "O", []string{}, "remove entities that are not referenced anywhere\ncomma-separated list of supported files:\nall,agency,attributions,routes,services,shapes,stops,transfers,translations,trips")
 	flag.Lookup("delete-orphans").NoOptDefVal = "all"
-	useShapeMinimizer := flag.BoolP("min-shapes", "s", false, "minimize shapes (using Douglas-Peucker)")
+	useShapeMinimizer := flag.BoolP("min-shapes", "s", false, "minimize shapes (using Douglas-Peucker or Visvalingam-Whyatt, see --min-shapes-algo)")
+	shapeMinimizerEpsilon := flag.Float64P("min-shapes-epsilon", "", 1.0, "max deviation (in meters) of minimized shapes from the original shapes for -s")
+	shapeMinimizerAlgo := flag.StringP("min-shapes-algo", "", "dp", "shape minimization algorithm for -s, either 'dp' (Douglas-Peucker) or 'vw' (Visvalingam-Whyatt)")
+	shapeMinimizerKeepStops := flag.BoolP("min-shapes-keep-stop-points", "", false, "for -s, keep the shape points enclosing the positions of stops on the shape")
 	useShapeRemeasurer := flag.BoolP("remeasure-shapes", "m", false, "remeasure shapes (filling measurement-holes)")
 	useStopTimeRemeasurer := flag.BoolP("remeasure-stop-times", "r", false, "remeasure stop times")
 	dropSingleStopTrips := flag.BoolP("drop-single-stop-trips", "", false, "drop trips with only 1 stop")
-	useShapeSnapper := flag.BoolP("snap-stops", "", false, "snap stop points to shape if dist > 100 m")
+	useShapeSnapper := flag.BoolP("snap-stops", "", false, "snap stop points to shape if dist > --snap-stops-max-dist")
+	shapeSnapperMaxDist := flag.Float64P("snap-stops-max-dist", "", 100, "max distance (in meters) between a stop and the trip's shape before the stop is snapped by --snap-stops")
+	shapeSnapperMove := flag.BoolP("snap-stops-move", "", false, "for --snap-stops, move stops instead of creating new stops if all their stop times are snapped to the same position")
+	shapeSnapperSideOffset := flag.Float64P("snap-stops-side-offset", "", 0, "for --snap-stops, place snapped stops this many meters to the right of the travel direction (negative values for the left)")
+	shapeSnapperReport := flag.StringP("snap-stops-report", "", "", "write stops moved or created by --snap-stops to this CSV file")
 	useRedShapeRemover := flag.BoolP("remove-red-shapes", "S", false, "remove shape duplicates")
+	useShapeRepairer := flag.BoolP("repair-shapes", "", false, "remove duplicate points, spikes and zig-zags from shapes, reverse shapes contradicting the stop order")
+	shapeRepairerMaxAngle := flag.Float64P("repair-shapes-max-angle", "", 15, "shape points at which the shape turns back with an angle (in degrees) below this are removed by --repair-shapes")
+	shapeRepairerMaxLen := flag.Float64P("repair-shapes-max-spike-len", "", 0, "only remove turns with --repair-shapes if one of their legs is not longer than this (in meters), 0 for no limit")
+	shapeRepairerReport := flag.StringP("repair-shapes-report", "", "", "write the number of repairs per shape done by --repair-shapes to this CSV file")
+	validateTripShapes := flag.StringP("validate-trip-shapes", "", "", "check whether the stops of each trip lie along its shape in the correct order, and either 'drop' inconsistent shape assignments, 'snap' stops too far away onto the shape, or only 'report' them")
+	validateTripShapesMaxDist := flag.Float64P("validate-trip-shapes-max-dist", "", 100, "max distance (in meters) between a stop and the trip's shape for --validate-trip-shapes")
+	validateTripShapesReport := flag.StringP("validate-trip-shapes-report", "", "", "write trips found inconsistent with their shape by --validate-trip-shapes to this CSV file")
+	genShapesOsm := flag.StringP("gen-shapes-osm", "", "", "generate shapes for trips without shapes by map-matching them onto the network in this OSM XML or PBF file")
+	genShapesMaxSnapDist := flag.Float64P("gen-shapes-max-snap-dist", "", 100, "max distance (in meters) between a stop and the network for --gen-shapes-osm")
+	genShapesMaxDetour := flag.Float64P("gen-shapes-max-detour", "", 3, "max ratio between network distance and straight-line distance of consecutive stops for --gen-shapes-osm")
+	genShapesStraight := flag.BoolP("gen-shapes-straight", "", false, "generate straight-line shapes between the stops of trips without shapes")
+	genShapesMaxSegLen := flag.Float64P("gen-shapes-max-seg-len", "", 0, "densify straight-line shapes from --gen-shapes-straight along the great circle so that no segment is longer than this (in meters, 0 = no densification)")
 	useRedRouteMinimizer := flag.BoolP("remove-red-routes", "R", false, "remove route duplicates")
 	useRedRouteMinimizerSharedStops := flag.BoolP("red-routes-must-share-station", "", false, "two routes are only merge if their trips share a station")
+	useRedFareMinimizer := flag.BoolP("remove-red-fares", "", false, "remove fare rules referencing nonexistent zones or routes, merge equivalent fare zones and fare attributes")
 	useRedServiceMinimizer := flag.BoolP("remove-red-services", "C", false, "remove duplicate services in calendar.txt and calendar_dates.txt")
-	useIDMinimizerNum := flag.BoolP("minimize-ids-num", "i", false, "minimize IDs using numerical IDs (e.g. 144, 145, 146...)")
-	useIDMinimizerChar := flag.BoolP("minimize-ids-char", "d", false, "minimize IDs using character IDs (e.g. abc, abd, abe, abf...)")
+	useIDMinimizerNum := flag.BoolP("minimize-ids-num", "i", false, "minimize IDs using numerical IDs (e.g. 144, 145, 146...), including fare zone IDs unless --keep-zone-ids is set")
+	useIDMinimizerChar := flag.BoolP("minimize-ids-char", "d", false, "minimize IDs using character IDs (e.g. abc, abd, abe, abf...), including fare zone IDs unless --keep-zone-ids is set")
 	useServiceMinimizer := flag.BoolP("minimize-services", "c", false, "minimize services by searching for the optimal exception/range coverage")
 	useFrequencyMinimizer := flag.BoolP("minimize-stoptimes", "T", false, "search for frequency patterns in explicit trips and combine them, using a CAP approach")
 	useCalDatesRemover := flag.BoolP("remove-cal-dates", "", false, "don't use calendar_dates.txt")
 	explicitCals := flag.BoolP("explicit-calendar", "", false, "add calendar.txt entry for every service, even irregular ones")
 	ensureTripHeadsigns := flag.BoolP("ensure-trip-headsigns", "", false, "write trip headsigns if missing")
 	ensureParents := flag.BoolP("ensure-stop-parents", "", false, "ensure that every stop (location_type=0) has a parent station")
+	propagateWheelchair := flag.BoolP("propagate-wheelchair-boarding", "", false, "inherit wheelchair_boarding from parent stations where unset, and check wheelchair information for contradictions")
+	wheelchairReport := flag.StringP("wheelchair-report", "", "", "write wheelchair accessibility contradictions and parent overrides found by --propagate-wheelchair-boarding to this CSV file")
+	wheelchairRouteStats := flag.StringP("wheelchair-route-stats", "", "", "write per-route wheelchair accessibility statistics computed by --propagate-wheelchair-boarding to this CSV file")
+	genPathways := flag.BoolP("gen-pathways", "", false, "generate walkway pathways between platforms and entrances for stations without pathways")
+	genPathwaysWalkingSpeed := flag.Float64P("gen-pathways-walking-speed", "", 1.2, "walking speed (in m/s) used to estimate traversal times with --gen-pathways")
+	genPathwaysLevels := flag.BoolP("gen-pathways-levels", "", false, "assign a ground level to all stops of stations processed by --gen-pathways which have no level")
+	feedMergeInfos := flag.BoolP("feed-merge-infos", "", false, "merge multiple feed_info.txt entries (for example from merged feeds) into a single one")
+	feedUpdateDates := flag.BoolP("feed-update-dates", "", false, "recompute feed_start_date and feed_end_date in feed_info.txt from the services used by trips")
+	feedVersion := flag.StringP("feed-version", "", "", "set feed_version in feed_info.txt from this template, may contain {date}, {start}, {end}, {hash} and {n} (counter incremented if the previous version matches)")
+	feedPublisherName := flag.StringP("feed-publisher-name", "", "", "set feed_publisher_name in feed_info.txt, feed_info.txt is created if missing")
+	feedPublisherUrl := flag.StringP("feed-publisher-url", "", "", "set feed_publisher_url in feed_info.txt")
+	feedLang := flag.StringP("feed-lang", "", "", "set feed_lang in feed_info.txt")
+	feedContactEmail := flag.StringP("feed-contact-email", "", "", "set feed_contact_email in feed_info.txt")
+	feedContactUrl := flag.StringP("feed-contact-url", "", "", "set feed_contact_url in feed_info.txt")
+	roundCoordsDecimals := flag.IntP("round-coords", "", 0, "round stop and shape coordinates to this number of decimals (0 = no rounding)")
+	roundCoordsMeters := flag.Float64P("round-coords-meters", "", 0, "round stop and shape coordinates to the fewest decimals with a precision of at least this number of meters (0 = no rounding)")
+	geojsonOut := flag.StringP("export-geojson", "", "", "write stops, parent station links, shapes and route stop patterns as GeoJSON files to this directory")
+	gpkgOut := flag.StringP("export-gpkg", "", "", "write stops, parent station links, shapes and route stop patterns as layers of a GeoPackage to this file")
+	shapeNetworkOut := flag.StringP("shape-network-out", "", "", "decompose all shapes into a network of shared segments and write it with the routes using each segment to this GeoJSON file")
+	shapeNetworkMaxDist := flag.Float64P("shape-network-max-dist", "", 15, "max distance (in meters) between shape parts considered the same segment for --shape-network-out")
+	faresV2Out := flag.StringP("fares-v2-out", "", "", "convert fare_attributes.txt and fare_rules.txt to fares v2 and write the fares v2 files to this directory")
+	faresV2Report := flag.StringP("fares-v2-report", "", "", "write fare constructs which --fares-v2-out could not convert exactly to this CSV file")
+	useRedTransferRemover := flag.BoolP("remove-red-transfers", "", false, "remove redundant transfers, lift transfers between all platforms of two stations to station level")
+	genTransfers := flag.BoolP("gen-transfers", "", false, "generate transfers between nearby stops served by different routes, existing transfers are kept")
+	genTransfersMaxDist := flag.Float64P("gen-transfers-max-dist", "", 200, "max walking distance (in meters) between stops for --gen-transfers")
+	genTransfersWalkingSpeed := flag.Float64P("gen-transfers-walking-speed", "", 1.2, "walking speed (in m/s) used to estimate min_transfer_time with --gen-transfers")
+	genTransfersStationTime := flag.IntP("gen-transfers-station-time", "", 120, "min. transfer time (in seconds) between stops of the same station with --gen-transfers")
+	genBlockTransfers := flag.BoolP("gen-block-transfers", "", false, "generate in-seat transfers (transfer_type=4) between consecutive trips of the same block")
 	keepColOrder := flag.BoolP("keep-col-order", "", false, "keep the original column ordering of the input feed")
 	keepFields := flag.BoolP("keep-additional-fields", "F", false, "keep all non-GTFS fields from the input")
 	dropTooFast := flag.BoolP("drop-too-fast-trips", "", false, "drop trips that are too fast to realistically occur")
@@ -186,9 +364,25 @@ func main() {
 	useStopReclusterer := flag.BoolP("recluster-stops", "E", false, "recluster stops")
 	stopReclusterDistance := flag.Float64P("recluster-stops-dist", "", 75.0, "distance threshold for stop reclustering with -E")
 	stopReclusterSimiThreshold := flag.Float64P("recluster-stops-simi", "", 0.55, "similarity threshold for stop reclustering with -E")
+	stopReclusterReportCSV := flag.StringP("recluster-stops-report-csv", "", "", "write every stop clustering decision of -E to this CSV file")
+	stopReclusterReportGeoJSON := flag.StringP("recluster-stops-report-geojson", "", "", "write every stop clustering decision of -E to this GeoJSON file")
+	stopReclusterOverridesFile := flag.StringP("recluster-stops-overrides", "", "", "CSV file with manual overrides for -E, one <stop_id>,<stop_id>,<force|never> triple per line")
+	stopReclusterFold := flag.BoolP("recluster-stops-fold", "", false, "fold diacritics and transliterate Cyrillic and Greek characters in stop names for -E")
+	stopReclusterTranslitFile := flag.StringP("recluster-stops-translit", "", "", "CSV file with additional transliterations for -E, one <from>,<to> pair per line, implies --recluster-stops-fold")
+	stopReclusterStopWordLangs := flag.StringSliceP("recluster-stops-stopword-langs", "", []string{}, "comma-separated list of languages (de,en,es,fr,it,nl) whose built-in stop words are ignored in stop names for -E")
+	stopReclusterStopWordsFile := flag.StringP("recluster-stops-stopwords", "", "", "file with additional stop words ignored in stop names for -E, one per line")
+	normalizeStopNames := flag.BoolP("normalize-stop-names", "", false, "normalize stop names (casing, abbreviations, whitespace)")
+	stopNameAbbrevsFile := flag.StringP("stop-name-abbrevs", "", "", "CSV file with abbreviation replacements for stop name normalization, one <from>,<to> pair per line")
+	stopNameLang := flag.StringP("stop-name-lang", "", "", "language code (e.g. de, en, fr) used for title casing exceptions in stop name normalization")
+	trimCityPrefixes := flag.BoolP("trim-city-prefixes", "", false, "remove city prefixes of the form 'City, Name' from stop names during stop name normalization")
+	matchNormalizedStopNames := flag.BoolP("match-normalized-stop-names", "", false, "compare normalized stop names in -P and -E, without changing the output names")
 	useStopAverager := flag.BoolP("fix-far-away-parents", "", false, "try to fix too far away parent stations by averaging their position to childrens")
 	dropShapes := flag.BoolP("drop-shapes", "", false, "drop shapes")
 	polygonFilterCompleteTrips := flag.BoolP("complete-filtered-trips", "", false, "always include complete data for trips filtered e.g. using a geo filter")
+	polygonFilterMinStops := flag.IntP("complete-filtered-trips-min-stops", "", 1, "for --complete-filtered-trips, min number of stops of a trip inside the polygons")
+	polygonFilterMinShare := flag.Float64P("complete-filtered-trips-min-share", "", 0, "for --complete-filtered-trips, min share (between 0 and 1) of stops of a trip inside the polygons")
+	polygonFilterMinDist := flag.Float64P("complete-filtered-trips-min-dist", "", 0, "for --complete-filtered-trips, min distance (in meters) a trip must travel inside the polygons")
+	polygonFilterShapeCrossing := flag.BoolP("complete-filtered-trips-shape-crossing", "", false, "for --complete-filtered-trips, also keep trips whose shape crosses the polygons without stopping inside")
 	flag.StringArrayVar(&bboxStrings, "bounding-box", []string{}, "bounding box filter, as comma separated latitude,longitude pairs (multiple boxes allowed by defining --bounding-box multiple times)")
 	flag.StringArrayVar(&polygonStrings, "polygon", []string{}, "polygon filter, as comma separated latitude,longitude pairs (multiple polygons allowed by defining --polygon multiple times)")
 	flag.StringArrayVar(&polygonFiles, "polygon-file", []string{}, "polygon filter, as a file containing comma separated latitude,longitude pairs (multiple polygons allowed by defining --polygon-file multiple times), or a GeoJSON file ending with .geojson or .json")
@@ -282,6 +476,7 @@ func main() {
 		*keepBlockIds = true
 		*keepPathwayIds = true
 		*keepAttributionIds = true
+		*keepZoneIds = true
 	}
 
 	if *fixShortHand {
@@ -301,6 +496,7 @@ func main() {
 
 	if *mergeShortHand {
 		*useServiceMinimizer = true
+		*useRedFareMinimizer = true
 		*useRedServiceMinimizer = true
 		*useRedTripMinimizer = true
 		*useRedAgencyMinimizer = true
@@ -331,14 +527,159 @@ func main() {
 		*useServiceMinimizer = true
 		*useRedTripMinimizer = true
 		*useRedAgencyMinimizer = true
+		*useRedFareMinimizer = true
+	}
+
+	if *shapeMinimizerAlgo != "dp" && *shapeMinimizerAlgo != "vw" {
+		fmt.Fprintf(os.Stderr, "Invalid shape minimization algorithm '%s', must be either 'dp' or 'vw'\n", *shapeMinimizerAlgo)
+		os.Exit(1)
+	}
+
+	if len(*validateTripShapes) > 0 && *validateTripShapes != "drop" && *validateTripShapes != "snap" && *validateTripShapes != "report" {
+		fmt.Fprintf(os.Stderr, "Invalid trip shape validation action '%s', must be either 'drop', 'snap' or 'report'\n", *validateTripShapes)
+		os.Exit(1)
+	}
+
+	if *roundCoordsDecimals > 0 && *roundCoordsMeters > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid coordinate rounding, --round-coords and --round-coords-meters cannot be combined\n")
+		os.Exit(1)
+	}
+
+	if len(*shapeNetworkOut) > 0 && *shapeNetworkMaxDist <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid shape network max distance %g, must be > 0\n", *shapeNetworkMaxDist)
+		os.Exit(1)
+	}
+
+	if *genPathways && *genPathwaysWalkingSpeed <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid pathway walking speed %g, must be > 0\n", *genPathwaysWalkingSpeed)
+		os.Exit(1)
+	}
+
+	if *genTransfers && *genTransfersWalkingSpeed <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid transfer walking speed %g, must be > 0\n", *genTransfersWalkingSpeed)
+		os.Exit(1)
+	}
+
+	if *polygonFilterMinShare < 0 || *polygonFilterMinShare > 1 {
+		fmt.Fprintf(os.Stderr, "Invalid min share of stops inside polygons %g, must be between 0 and 1\n", *polygonFilterMinShare)
+		os.Exit(1)
+	}
+
+	fu := processors.FeedInfoUpdater{MergeInfos: *feedMergeInfos, UpdateDates: *feedUpdateDates, VersionTmpl: *feedVersion, PublisherName: *feedPublisherName}
+
+	if len(*feedPublisherUrl) > 0 {
+		u, err := url.ParseRequestURI(*feedPublisherUrl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid feed publisher URL: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fu.PublisherUrl = u
 	}
 
+	if len(*feedContactUrl) > 0 {
+		u, err := url.ParseRequestURI(*feedContactUrl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid feed contact URL: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fu.ContactUrl = u
+	}
+
+	if len(*feedContactEmail) > 0 {
+		m, err := mail.ParseAddress(*feedContactEmail)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid feed contact email: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fu.ContactEmail = m
+	}
+
+	if len(*feedLang) > 0 {
+		l, err := gtfs.NewLanguageISO6391(*feedLang)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid feed language: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fu.Lang = &l
+	}
+
+	useFeedInfoUpdater := fu.MergeInfos || fu.UpdateDates || len(fu.VersionTmpl) > 0 || len(fu.PublisherName) > 0 || fu.PublisherUrl != nil || fu.ContactUrl != nil || fu.ContactEmail != nil || fu.Lang != nil
+
 	or, err := processors.MakeOrphanRemover(*orphanDeleters)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing filter: %s\n", err)
 		os.Exit(1)
 	}
 
+	var nameNormalizer *processors.StopNameNormalizer
+
+	if *normalizeStopNames || *matchNormalizedStopNames {
+		nameNormalizer = &processors.StopNameNormalizer{Lang: *stopNameLang, TrimCityPrefixes: *trimCityPrefixes}
+
+		if len(*stopNameAbbrevsFile) > 0 {
+			nameNormalizer.Abbrevs, err = parseReplacements(*stopNameAbbrevsFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "\nCould not parse abbreviation file: ")
+				fmt.Fprintf(os.Stderr, err.Error()+".\n")
+				os.Exit(1)
+			}
+		}
+	}
+
+	var matchNormalizer *processors.StopNameNormalizer
+
+	if *matchNormalizedStopNames {
+		matchNormalizer = nameNormalizer
+	}
+
+	var nameFolder *processors.NameFolder
+	stopWords := make([]string, 0)
+
+	if *stopReclusterFold || len(*stopReclusterTranslitFile) > 0 {
+		translit := make(map[string]string)
+
+		if len(*stopReclusterTranslitFile) > 0 {
+			translit, err = parseReplacements(*stopReclusterTranslitFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "\nCould not parse transliteration file: ")
+				fmt.Fprintf(os.Stderr, err.Error()+".\n")
+				os.Exit(1)
+			}
+		}
+
+		nameFolder = processors.NewNameFolder(translit)
+	}
+
+	for _, lang := range *stopReclusterStopWordLangs {
+		words := processors.BuiltinStopWords(lang)
+		if words == nil {
+			fmt.Fprintf(os.Stderr, "No built-in stop words for language '%s'\n", lang)
+			os.Exit(1)
+		}
+		stopWords = append(stopWords, words...)
+	}
+
+	if len(*stopReclusterStopWordsFile) > 0 {
+		words, err := parseWordList(*stopReclusterStopWordsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse stop word file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+		stopWords = append(stopWords, words...)
+	}
+
+	var forceMerge, neverMerge [][2]string
+
+	if len(*stopReclusterOverridesFile) > 0 {
+		forceMerge, neverMerge, err = parseClusterOverrides(*stopReclusterOverridesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nCould not parse stop cluster overrides file: ")
+			fmt.Fprintf(os.Stderr, err.Error()+".\n")
+			os.Exit(1)
+		}
+	}
+
 	for _, polyFile := range polygonFiles {
 		if strings.HasSuffix(polyFile, ".json") || strings.HasSuffix(polyFile, ".geojson") {
 			json, err := ioutil.ReadFile(polyFile)
@@ -543,6 +884,18 @@ func main() {
 		} else {
 			fmt.Fprintf(os.Stdout, " done.\n")
 		}
+
+		if present := getPresentFiles(gtfsPath, faresV2Files); len(present) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: fares v2 files are not supported and will not be written to the output, fares v2 data in %s will be lost!\n", strings.Join(present, ", "))
+		}
+
+		if present := getPresentFiles(gtfsPath, translationFiles); len(present) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: translations cannot be written to the output, translations from %s will be lost!\n", strings.Join(present, ", "))
+		}
+
+		if present := getPresentFiles(gtfsPath, flexFiles); len(present) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: GTFS-Flex files are not supported and will not be written to the output, Flex data in %s will be lost!\n", strings.Join(present, ", "))
+		}
 	}
 
 	if e != nil {
@@ -558,13 +911,23 @@ func main() {
 		}
 
 		if *polygonFilterCompleteTrips {
-			minzers = append(minzers, processors.CompleteTripsGeoFilter{Polygons: polys})
+			minzers = append(minzers, processors.CompleteTripsGeoFilter{
+				Polygons:      polys,
+				MinStops:      *polygonFilterMinStops,
+				MinShare:      *polygonFilterMinShare,
+				MinDist:       *polygonFilterMinDist,
+				ShapeCrossing: *polygonFilterShapeCrossing,
+			})
 		}
 
 		if or.Enabled {
 			minzers = append(minzers, or)
 		}
 
+		if *useRedFareMinimizer {
+			minzers = append(minzers, processors.FareDuplicateRemover{})
+		}
+
 		if *useRedAgencyMinimizer {
 			minzers = append(minzers, processors.AgencyDuplicateRemover{})
 		}
@@ -575,12 +938,21 @@ func main() {
 			})
 		}
 
+		if *propagateWheelchair {
+			minzers = append(minzers, processors.WheelchairBoardingPropagator{ReportFile: *wheelchairReport, RouteStatsFile: *wheelchairRouteStats})
+		}
+
+		if *normalizeStopNames {
+			minzers = append(minzers, *nameNormalizer)
+		}
+
 		if *useRedStopMinimizer {
 			minzers = append(minzers, processors.StopDuplicateRemover{
 				DistThresholdStop:    5.0,
 				DistThresholdStation: 50,
 				Fuzzy:                *useRedStopsMinimizerFuzzy,
 				KeepIFOPT:            *keepStationIFTOPTIds,
+				NameNormalizer:       matchNormalizer,
 			})
 		}
 
@@ -589,6 +961,13 @@ func main() {
 				DistThreshold:     *stopReclusterDistance,
 				NameSimiThreshold: *stopReclusterSimiThreshold,
 				GridCellSize:      10000,
+				NameNormalizer:    matchNormalizer,
+				NameFolder:        nameFolder,
+				StopWords:         stopWords,
+				ForceMerge:        forceMerge,
+				NeverMerge:        neverMerge,
+				ReportCSV:         *stopReclusterReportCSV,
+				ReportGeoJSON:     *stopReclusterReportGeoJSON,
 			})
 		}
 
@@ -601,29 +980,60 @@ func main() {
 				DistThresholdStation: 50,
 				Fuzzy:                *useRedStopsMinimizerFuzzy,
 				KeepIFOPT:            *keepStationIFTOPTIds,
+				NameNormalizer:       matchNormalizer,
 			})
 		}
 
-		if *useShapeRemeasurer || *useShapeMinimizer || *useRedShapeRemover || *useStopTimeRemeasurer {
+		if *useShapeRepairer {
+			minzers = append(minzers, processors.ShapeRepairer{MaxSpikeAngle: *shapeRepairerMaxAngle, MaxSpikeLen: *shapeRepairerMaxLen, MaxStopDist: 50, ReportFile: *shapeRepairerReport})
+		}
+
+		if len(*genShapesOsm) > 0 {
+			minzers = append(minzers, processors.ShapeGenerator{OsmFile: *genShapesOsm, MaxSnapDist: *genShapesMaxSnapDist, MaxDetour: *genShapesMaxDetour, MaxCands: 8, SnapPenalty: 2})
+		}
+
+		if *genShapesStraight {
+			// fallback for trips which could not be matched
+			minzers = append(minzers, processors.StraightShapeGenerator{MaxSegLen: *genShapesMaxSegLen})
+		}
+
+		if len(*genShapesOsm) > 0 || *genShapesStraight {
+			// trips with different stop sequences may share the same generated shape
+			if !*useRedShapeRemover {
+				minzers = append(minzers, processors.ShapeDuplicateRemover{MaxEqDist: 1.0})
+			}
+		}
+
+		if len(*validateTripShapes) > 0 {
+			minzers = append(minzers, processors.ShapeTripValidator{MaxDist: *validateTripShapesMaxDist, Action: *validateTripShapes, ReportFile: *validateTripShapesReport})
+		}
+
+		if *useShapeRemeasurer || *useShapeMinimizer || *useRedShapeRemover || *useStopTimeRemeasurer || *useShapeRepairer {
 			minzers = append(minzers, processors.ShapeRemeasurer{Force: *useStopTimeRemeasurer})
 		}
 
+		// stop times are measured before minimization if their positions on the shape should be kept
+		if *useStopTimeRemeasurer && *useShapeMinimizer && *shapeMinimizerKeepStops {
+			minzers = append(minzers, processors.StopTimeRemeasurer{})
+		}
+
 		if *useShapeMinimizer {
-			minzers = append(minzers, processors.ShapeMinimizer{Epsilon: 1.0})
+			minzers = append(minzers, processors.ShapeMinimizer{Epsilon: *shapeMinimizerEpsilon, Algo: *shapeMinimizerAlgo, KeepStopPoints: *shapeMinimizerKeepStops})
 		}
 
-		if *useStopTimeRemeasurer {
+		if *useStopTimeRemeasurer && !(*useShapeMinimizer && *shapeMinimizerKeepStops) {
 			minzers = append(minzers, processors.StopTimeRemeasurer{})
 		}
 
 		if *useShapeSnapper {
-			minzers = append(minzers, processors.ShapeSnapper{MaxDist: 100.0})
+			minzers = append(minzers, processors.ShapeSnapper{MaxDist: *shapeSnapperMaxDist, MoveStops: *shapeSnapperMove, SideOffset: *shapeSnapperSideOffset, ReportFile: *shapeSnapperReport})
 			if *useRedStopMinimizer {
 				minzers = append(minzers, processors.StopDuplicateRemover{
 					DistThresholdStop:    5.0,
 					DistThresholdStation: 50,
 					Fuzzy:                *useRedStopsMinimizerFuzzy,
 					KeepIFOPT:            *keepStationIFTOPTIds,
+					NameNormalizer:       matchNormalizer,
 				})
 			}
 
@@ -633,6 +1043,14 @@ func main() {
 			}
 		}
 
+		if *roundCoordsDecimals > 0 || *roundCoordsMeters > 0 {
+			decimals := *roundCoordsDecimals
+			if *roundCoordsMeters > 0 {
+				decimals = processors.DecimalsForMeters(*roundCoordsMeters)
+			}
+			minzers = append(minzers, processors.CoordinateRounder{Decimals: decimals})
+		}
+
 		if *useRedShapeRemover {
 			minzers = append(minzers, processors.ShapeDuplicateRemover{MaxEqDist: 1.0})
 		}
@@ -693,10 +1111,26 @@ func main() {
 			minzers = append(minzers, processors.StopParentEnforcer{})
 		}
 
+		if *useRedTransferRemover {
+			minzers = append(minzers, processors.TransferMinimizer{})
+		}
+
+		if *genPathways {
+			minzers = append(minzers, processors.PathwayGenerator{WalkingSpeed: *genPathwaysWalkingSpeed, GenerateLevels: *genPathwaysLevels})
+		}
+
+		if *genTransfers {
+			minzers = append(minzers, processors.TransferGenerator{MaxDist: *genTransfersMaxDist, WalkingSpeed: *genTransfersWalkingSpeed, StationTransferTime: *genTransfersStationTime})
+		}
+
+		if *genBlockTransfers {
+			minzers = append(minzers, processors.BlockTransferGenerator{})
+		}
+
 		if *useIDMinimizerNum {
-			minzers = append(minzers, processors.IDMinimizer{Prefix: *idPrefix, Base: 10, KeepStations: *keepStationIds, KeepBlocks: *keepBlockIds, KeepFares: *keepFareIds, KeepShapes: *keepShapeIds, KeepRoutes: *keepRouteIds, KeepTrips: *keepTripIds, KeepLevels: *keepLevelIds, KeepServices: *keepServiceIds, KeepAgencies: *keepAgencyIds, KeepPathways: *keepPathwayIds, KeepAttributions: *keepAttributionIds})
+			minzers = append(minzers, processors.IDMinimizer{Prefix: *idPrefix, Base: 10, KeepStations: *keepStationIds, KeepBlocks: *keepBlockIds, KeepFares: *keepFareIds, KeepShapes: *keepShapeIds, KeepRoutes: *keepRouteIds, KeepTrips: *keepTripIds, KeepLevels: *keepLevelIds, KeepServices: *keepServiceIds, KeepAgencies: *keepAgencyIds, KeepPathways: *keepPathwayIds, KeepAttributions: *keepAttributionIds, KeepZones: *keepZoneIds})
 		} else if *useIDMinimizerChar {
-			minzers = append(minzers, processors.IDMinimizer{Prefix: *idPrefix, Base: 36, KeepStations: *keepStationIds, KeepBlocks: *keepBlockIds, KeepFares: *keepFareIds, KeepShapes: *keepShapeIds, KeepRoutes: *keepRouteIds, KeepTrips: *keepTripIds, KeepLevels: *keepLevelIds, KeepServices: *keepServiceIds, KeepAgencies: *keepAgencyIds, KeepPathways: *keepPathwayIds, KeepAttributions: *keepAttributionIds})
+			minzers = append(minzers, processors.IDMinimizer{Prefix: *idPrefix, Base: 36, KeepStations: *keepStationIds, KeepBlocks: *keepBlockIds, KeepFares: *keepFareIds, KeepShapes: *keepShapeIds, KeepRoutes: *keepRouteIds, KeepTrips: *keepTripIds, KeepLevels: *keepLevelIds, KeepServices: *keepServiceIds, KeepAgencies: *keepAgencyIds, KeepPathways: *keepPathwayIds, KeepAttributions: *keepAttributionIds, KeepZones: *keepZoneIds})
 		}
 
 		// do processing
@@ -963,6 +1397,24 @@ func main() {
 			}
 		}
 
+		// update feed info after all other changes, for a correct content hash
+		if useFeedInfoUpdater {
+			fu.Run(feed)
+		}
+
+		// convert fares after all IDs have been finalized
+		if len(*faresV2Out) > 0 {
+			processors.FareV1ToV2Converter{OutPath: *faresV2Out, ReportFile: *faresV2Report}.Run(feed)
+		}
+
+		if len(*geojsonOut) > 0 || len(*gpkgOut) > 0 {
+			processors.NetworkExporter{OutPath: *geojsonOut, GpkgFile: *gpkgOut}.Run(feed)
+		}
+
+		if len(*shapeNetworkOut) > 0 {
+			processors.ShapeNetworkExporter{OutFile: *shapeNetworkOut, MaxEqDist: *shapeNetworkMaxDist}.Run(feed)
+		}
+
 		fmt.Fprintf(os.Stdout, "Outputting GTFS feed to '%s'...", *outputPath)
 
 		if _, err := os.Stat(*outputPath); os.IsNotExist(err) {
@@ -122,9 +122,7 @@ func (adr *AgencyDuplicateRemover) combineAgencies(feed *gtfsparser.Feed, agenci
 			}
 		}
 
-		for _, attr := range a.Attributions {
-			ref.Attributions = append(ref.Attributions, attr)
-		}
+		ref.Attributions = mergeAttributions(ref.Attributions, a.Attributions)
 
 		for _, fa := range fareattrs[a] {
 			if fa.Agency == a {
@@ -132,6 +130,8 @@ func (adr *AgencyDuplicateRemover) combineAgencies(feed *gtfsparser.Feed, agenci
 			}
 		}
 
+		ref.Translations = mergeTranslations(ref.Translations, a.Translations)
+
 		feed.DeleteAgency(a.Id)
 	}
 }
@@ -0,0 +1,62 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// Return a key which is equal for two attributions iff they only differ in
+// their ID
+func attributionKey(a *gtfs.Attribution) string {
+	email := ""
+	if a.Email != nil {
+		email = a.Email.String()
+	}
+
+	u := ""
+	if a.Url != nil {
+		u = a.Url.String()
+	}
+
+	return fmt.Sprintf("%s\x00%t\x00%t\x00%t\x00%s\x00%s\x00%s", a.Organization_name, a.Is_producer, a.Is_operator, a.Is_authority, email, u, a.Phone)
+}
+
+// Return the union of the attributions ref and b, without duplicates
+func mergeAttributions(ref []*gtfs.Attribution, b []*gtfs.Attribution) []*gtfs.Attribution {
+	has := make(map[string]bool, len(ref))
+	for _, attr := range ref {
+		has[attributionKey(attr)] = true
+	}
+
+	for _, attr := range b {
+		key := attributionKey(attr)
+		if has[key] {
+			continue
+		}
+		has[key] = true
+		ref = append(ref, attr)
+	}
+
+	return ref
+}
+
+// Merge the attributions of trip t into trip ref
+func mergeTripAttributions(ref *gtfs.Trip, t *gtfs.Trip) {
+	if t.Attributions == nil || len(*t.Attributions) == 0 {
+		return
+	}
+
+	if ref.Attributions == nil {
+		sl := make([]*gtfs.Attribution, 0)
+		ref.Attributions = &sl
+	}
+
+	*ref.Attributions = mergeAttributions(*ref.Attributions, *t.Attributions)
+}
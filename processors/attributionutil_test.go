@@ -0,0 +1,50 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestMergeAttributions(t *testing.T) {
+	a := &gtfs.Attribution{Id: "a", Organization_name: "Org", Is_producer: true}
+	b := &gtfs.Attribution{Id: "b", Organization_name: "Org", Is_producer: true}
+	c := &gtfs.Attribution{Id: "c", Organization_name: "Org", Is_operator: true}
+	d := &gtfs.Attribution{Id: "d", Organization_name: "Other", Is_producer: true}
+
+	ret := mergeAttributions([]*gtfs.Attribution{a}, []*gtfs.Attribution{b, c, d, c})
+
+	if len(ret) != 3 || ret[0] != a || ret[1] != c || ret[2] != d {
+		t.Error("Attributions only differing in their ID should have been merged")
+	}
+
+	if attributionKey(a) != attributionKey(b) || attributionKey(a) == attributionKey(c) {
+		t.Error("Unexpected attribution keys")
+	}
+}
+
+func TestMergeTripAttributions(t *testing.T) {
+	a := &gtfs.Trip{Id: "a"}
+	b := &gtfs.Trip{Id: "b"}
+
+	attrs := []*gtfs.Attribution{{Id: "x", Organization_name: "Org", Is_operator: true}, {Id: "y", Organization_name: "Org", Is_operator: true}}
+	b.Attributions = &attrs
+
+	mergeTripAttributions(a, b)
+
+	if a.Attributions == nil || len(*a.Attributions) != 1 || (*a.Attributions)[0] != attrs[0] {
+		t.Error("Attributions of b should have been merged into a without duplicates")
+	}
+
+	// trips without attributions do not change anything
+	mergeTripAttributions(a, &gtfs.Trip{Id: "c"})
+
+	if len(*a.Attributions) != 1 {
+		t.Error("Attributions of a were modified")
+	}
+}
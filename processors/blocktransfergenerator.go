@@ -0,0 +1,137 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// BlockTransferGenerator generates in-seat transfers (transfer_type=4)
+// between consecutive trips of the same block which operate on at least one
+// common day, if the second trip departs from the stop (or station) the first
+// trip arrives at. Existing transfers between two trips are kept.
+type BlockTransferGenerator struct {
+	overlaps map[[2]*gtfs.Service]bool
+}
+
+// Run this BlockTransferGenerator on some feed
+func (bg BlockTransferGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating in-seat transfers from blocks... ")
+
+	bef := len(feed.Transfers)
+	bg.overlaps = make(map[[2]*gtfs.Service]bool)
+
+	blocks := make(map[string][]*gtfs.Trip)
+	for _, t := range feed.Trips {
+		if t.Block_id == nil || len(*t.Block_id) == 0 || len(t.StopTimes) == 0 {
+			continue
+		}
+
+		// frequency-based trips have no fixed successor
+		if t.Frequencies != nil && len(*t.Frequencies) > 0 {
+			continue
+		}
+
+		blocks[*t.Block_id] = append(blocks[*t.Block_id], t)
+	}
+
+	existing := make(map[*gtfs.Trip]map[*gtfs.Trip]bool)
+	for tk := range feed.Transfers {
+		if tk.From_trip == nil || tk.To_trip == nil {
+			continue
+		}
+		if _, ok := existing[tk.From_trip]; !ok {
+			existing[tk.From_trip] = make(map[*gtfs.Trip]bool)
+		}
+		existing[tk.From_trip][tk.To_trip] = true
+	}
+
+	for _, trips := range blocks {
+		sort.Slice(trips, func(i, j int) bool {
+			a := trips[i].StopTimes[0].Departure_time().SecondsSinceMidnight()
+			b := trips[j].StopTimes[0].Departure_time().SecondsSinceMidnight()
+			if a != b {
+				return a < b
+			}
+			return trips[i].Id < trips[j].Id
+		})
+
+		for i, a := range trips {
+			last := a.StopTimes[len(a.StopTimes)-1]
+
+			for j := i + 1; j < len(trips); j++ {
+				b := trips[j]
+				first := b.StopTimes[0]
+
+				if first.Departure_time().SecondsSinceMidnight() < last.Arrival_time().SecondsSinceMidnight() {
+					continue
+				}
+
+				if !bg.servicesOverlap(a.Service, b.Service) {
+					continue
+				}
+
+				// b is the next trip of a's vehicle
+				if bg.station(first.Stop()) == bg.station(last.Stop()) && !existing[a][b] {
+					tk := gtfs.TransferKey{From_stop: last.Stop(), To_stop: first.Stop(), From_trip: a, To_trip: b}
+					feed.Transfers[tk] = gtfs.TransferVal{Transfer_type: 4, Min_transfer_time: -1}
+				}
+
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d transfers)\n", len(feed.Transfers)-bef)
+}
+
+// Return the parent station of a stop, or the stop itself
+func (bg BlockTransferGenerator) station(s *gtfs.Stop) *gtfs.Stop {
+	if s.Parent_station != nil {
+		return s.Parent_station
+	}
+	return s
+}
+
+// Check if two services have at least one active day in common
+func (bg BlockTransferGenerator) servicesOverlap(a *gtfs.Service, b *gtfs.Service) bool {
+	if a == b {
+		return true
+	}
+
+	if ret, ok := bg.overlaps[[2]*gtfs.Service{a, b}]; ok {
+		return ret
+	}
+
+	start := a.GetFirstDefinedDate()
+	if bStart := b.GetFirstDefinedDate(); bStart.GetTime().After(start.GetTime()) {
+		start = bStart
+	}
+
+	end := a.GetLastDefinedDate()
+	if bEnd := b.GetLastDefinedDate(); bEnd.GetTime().Before(end.GetTime()) {
+		end = bEnd
+	}
+
+	ret := false
+	for d := start; !d.GetTime().After(end.GetTime()); d = d.GetOffsettedDate(1) {
+		if a.IsActiveOn(d) && b.IsActiveOn(d) {
+			ret = true
+			break
+		}
+	}
+
+	bg.overlaps[[2]*gtfs.Service{a, b}] = ret
+	bg.overlaps[[2]*gtfs.Service{b, a}] = ret
+
+	return ret
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestBlockTransferGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	proc := BlockTransferGenerator{}
+	proc.Run(feed)
+
+	if len(feed.Transfers) != 1 {
+		t.Errorf("Expected 1 transfer, got %d", len(feed.Transfers))
+		return
+	}
+
+	tk := gtfs.TransferKey{From_stop: feed.Stops["BULLFROG"], To_stop: feed.Stops["BULLFROG"], From_trip: feed.Trips["AB1"], To_trip: feed.Trips["BFC1"]}
+
+	if v, ok := feed.Transfers[tk]; !ok || v.Transfer_type != 4 {
+		t.Error("Expected in-seat transfer from AB1 to BFC1 at BULLFROG")
+	}
+
+	// trip-scoped transfers must follow merged trips
+	ref := &gtfs.Trip{Id: "REF"}
+	feed.Trips["REF"] = ref
+	idx := getTripTransfers(feed)
+	redirectTripTransfers(feed, idx, feed.Trips["BFC1"], ref)
+
+	tk.To_trip = ref
+
+	if v, ok := feed.Transfers[tk]; !ok || v.Transfer_type != 4 || len(feed.Transfers) != 1 {
+		t.Error("Expected in-seat transfer to be redirected to REF")
+	}
+}
@@ -9,14 +9,26 @@ package processors
 import (
 	"github.com/patrickbr/gtfsparser"
 	"github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"sort"
 )
 
-// StopDuplicateRemover merges semantically equivalent stops
+// CompleteTripsGeoFilter keeps complete trips which serve the region
+// described by Polygons. A trip serves the region if at least MinStops
+// (at least 1) of its stops and at least a share of MinShare of its stops
+// are inside the polygons, or, if ShapeCrossing is set, if its shape (or,
+// for trips without a shape, the straight lines between its stops) crosses
+// the polygons. If MinDist is > 0, the trip must additionally travel at
+// least MinDist meters inside the polygons.
 type CompleteTripsGeoFilter struct {
-	Polygons []gtfsparser.Polygon
+	Polygons      []gtfsparser.Polygon
+	MinStops      int
+	MinShare      float64
+	MinDist       float64
+	ShapeCrossing bool
 }
 
-// Run this StopDuplicateRemover on some feed
+// Run this CompleteTripsGeoFilter on some feed
 func (f CompleteTripsGeoFilter) Run(feed *gtfsparser.Feed) {
 	// collect stops within the polygons
 	filterstops := make(map[*gtfs.Stop]bool, 0)
@@ -35,12 +47,35 @@ func (f CompleteTripsGeoFilter) Run(feed *gtfsparser.Feed) {
 		}
 	}
 
+	// in-polygon distances of shapes and stop sequences
+	distCache := make(map[interface{}]float64)
+
+	// bounding boxes of the polygons, as [minx, miny, maxx, maxy]
+	boxes := make([][4]float64, len(f.Polygons))
+	for i, poly := range f.Polygons {
+		boxes[i] = [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, c := range poly.OuterRing {
+			boxes[i] = [4]float64{math.Min(boxes[i][0], c[0]), math.Min(boxes[i][1], c[1]), math.Max(boxes[i][2], c[0]), math.Max(boxes[i][3], c[1])}
+		}
+	}
+
 	for id, t := range feed.Trips {
-		contained := false
+		inside := 0
 		for _, st := range t.StopTimes {
 			if _, ok := filterstops[st.Stop()]; ok {
+				inside++
+			}
+		}
+
+		contained := inside >= imax(1, f.MinStops) && float64(inside) >= f.MinShare*float64(len(t.StopTimes))
+
+		if (f.ShapeCrossing && !contained) || f.MinDist > 0 {
+			d := f.insideDist(t, boxes, distCache)
+			if f.ShapeCrossing && d > 0 {
 				contained = true
-				break
+			}
+			if f.MinDist > 0 && d < f.MinDist {
+				contained = false
 			}
 		}
 
@@ -85,3 +120,125 @@ func (f CompleteTripsGeoFilter) Run(feed *gtfsparser.Feed) {
 	// delete transfers
 	feed.CleanTransfers()
 }
+
+// Return the distance (in meters) trip t travels inside the polygons
+func (f CompleteTripsGeoFilter) insideDist(t *gtfs.Trip, boxes [][4]float64, cache map[interface{}]float64) float64 {
+	var key interface{}
+	coords := make([][2]float64, 0)
+
+	if t.Shape != nil {
+		key = t.Shape
+		if d, ok := cache[key]; ok {
+			return d
+		}
+		for _, p := range t.Shape.Points {
+			coords = append(coords, [2]float64{float64(p.Lon), float64(p.Lat)})
+		}
+	} else {
+		key = stopSeqKey(t)
+		if d, ok := cache[key]; ok {
+			return d
+		}
+		for _, st := range t.StopTimes {
+			lat, lon := getStopLatLon(st.Stop())
+			coords = append(coords, [2]float64{float64(lon), float64(lat)})
+		}
+	}
+
+	d := 0.0
+	for i := 1; i < len(coords); i++ {
+		a := coords[i-1]
+		b := coords[i]
+
+		// parts of the segment inside any polygon, overlapping polygons
+		// are only counted once
+		intervals := make([][2]float64, 0)
+		for j := range f.Polygons {
+			if math.Max(a[0], b[0]) < boxes[j][0] || math.Min(a[0], b[0]) > boxes[j][2] || math.Max(a[1], b[1]) < boxes[j][1] || math.Min(a[1], b[1]) > boxes[j][3] {
+				continue
+			}
+			intervals = append(intervals, f.segInside(&f.Polygons[j], a, b)...)
+		}
+
+		if frac := f.unionLength(intervals); frac > 0 {
+			d += frac * haversine(a[1], a[0], b[1], b[0])
+		}
+	}
+
+	cache[key] = d
+	return d
+}
+
+// Return the intervals of segment a-b inside a polygon, as positions
+// between 0 (at a) and 1 (at b)
+func (f CompleteTripsGeoFilter) segInside(poly *gtfsparser.Polygon, a [2]float64, b [2]float64) [][2]float64 {
+	// split the segment at all intersections with the polygon rings
+	ts := []float64{0, 1}
+
+	rings := append([][][2]float64{poly.OuterRing}, poly.InnerRings...)
+	for _, ring := range rings {
+		for i := range ring {
+			p := ring[i]
+			q := ring[(i+1)%len(ring)]
+			if t, ok := segIntersection(a, b, p, q); ok {
+				ts = append(ts, t)
+			}
+		}
+	}
+
+	sort.Float64s(ts)
+
+	ret := make([][2]float64, 0)
+	for i := 1; i < len(ts); i++ {
+		if ts[i] == ts[i-1] {
+			continue
+		}
+		mid := (ts[i-1] + ts[i]) / 2
+		if poly.PolyContains(a[0]+mid*(b[0]-a[0]), a[1]+mid*(b[1]-a[1])) {
+			ret = append(ret, [2]float64{ts[i-1], ts[i]})
+		}
+	}
+
+	return ret
+}
+
+// Return the total length of the union of intervals
+func (f CompleteTripsGeoFilter) unionLength(intervals [][2]float64) float64 {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i][0] < intervals[j][0]
+	})
+
+	l := 0.0
+	end := math.Inf(-1)
+	for _, iv := range intervals {
+		if iv[1] <= end {
+			continue
+		}
+		l += iv[1] - math.Max(iv[0], end)
+		end = iv[1]
+	}
+
+	return l
+}
+
+// Return the position t on segment a-b where it intersects segment p-q
+func segIntersection(a [2]float64, b [2]float64, p [2]float64, q [2]float64) (float64, bool) {
+	rx := b[0] - a[0]
+	ry := b[1] - a[1]
+	sx := q[0] - p[0]
+	sy := q[1] - p[1]
+
+	den := rx*sy - ry*sx
+	if math.Abs(den) < 1e-15 {
+		return 0, false
+	}
+
+	t := ((p[0]-a[0])*sy - (p[1]-a[1])*sx) / den
+	u := ((p[0]-a[0])*ry - (p[1]-a[1])*rx) / den
+
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+)
+
+// box which the shapes A_shp and B_shp cross between lat 2 and 2.5, but
+// which contains no stop
+var geoFilterTestPoly = gtfsparser.NewPolygon([][2]float64{{0.5, 2}, {1.5, 2}, {1.5, 2.5}, {0.5, 2.5}, {0.5, 2}}, nil)
+
+func TestCompleteTripsGeoFilterStops(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	CompleteTripsGeoFilter{Polygons: []gtfsparser.Polygon{geoFilterTestPoly}}.Run(feed)
+
+	if len(feed.Trips) != 0 {
+		t.Error(len(feed.Trips))
+	}
+}
+
+func TestCompleteTripsGeoFilterShapeCrossing(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	CompleteTripsGeoFilter{Polygons: []gtfsparser.Polygon{geoFilterTestPoly}, ShapeCrossing: true}.Run(feed)
+
+	if len(feed.Trips) != 2 || feed.Trips["AB1"] == nil || feed.Trips["AB2"] == nil {
+		t.Error(feed.Trips)
+	}
+
+	// complete trips are kept
+	if feed.Stops["BEATTY_AIRPORT"] == nil || feed.Stops["BULLFROG"] == nil {
+		t.Error("expected stops of kept trips")
+	}
+}
+
+func TestCompleteTripsGeoFilterMinDist(t *testing.T) {
+	// the shapes travel about 55.6 km inside the box
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+	CompleteTripsGeoFilter{Polygons: []gtfsparser.Polygon{geoFilterTestPoly}, ShapeCrossing: true, MinDist: 50000}.Run(feed)
+
+	if len(feed.Trips) != 2 {
+		t.Error(len(feed.Trips))
+	}
+
+	feed = gtfsparser.NewFeed()
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+	CompleteTripsGeoFilter{Polygons: []gtfsparser.Polygon{geoFilterTestPoly}, ShapeCrossing: true, MinDist: 60000}.Run(feed)
+
+	if len(feed.Trips) != 0 {
+		t.Error(len(feed.Trips))
+	}
+}
+
+func TestCompleteTripsGeoFilterOverlappingPolygons(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the distance inside both boxes is only counted once
+	inner := gtfsparser.NewPolygon([][2]float64{{0.5, 2.1}, {1.5, 2.1}, {1.5, 2.4}, {0.5, 2.4}, {0.5, 2.1}}, nil)
+	CompleteTripsGeoFilter{Polygons: []gtfsparser.Polygon{geoFilterTestPoly, inner}, ShapeCrossing: true, MinDist: 60000}.Run(feed)
+
+	if len(feed.Trips) != 0 {
+		t.Error(len(feed.Trips))
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/patrickbr/gtfsparser"
+)
+
+// CoordinateRounder rounds the coordinates of stops and shape points to
+// the given number of decimals. Consecutive shape points which become
+// identical are collapsed, and the affected shapes are remeasured.
+type CoordinateRounder struct {
+	Decimals int
+}
+
+// DecimalsForMeters returns the smallest number of decimals for which the
+// precision of coordinates is at least the given number of meters
+func DecimalsForMeters(m float64) int {
+	if m <= 0 {
+		return 7
+	}
+	return int(math.Max(0, math.Ceil(math.Log10(111319.4/m))))
+}
+
+// Run this CoordinateRounder on some feed
+func (cr CoordinateRounder) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Rounding coordinates to %d decimals... ", cr.Decimals)
+
+	for _, s := range feed.Stops {
+		if math.IsNaN(float64(s.Lat)) || math.IsNaN(float64(s.Lon)) {
+			continue
+		}
+		s.Lat = cr.round(s.Lat)
+		s.Lon = cr.round(s.Lon)
+	}
+
+	collapsed := 0
+	numPoints := 0
+
+	for _, s := range feed.Shapes {
+		numPoints += len(s.Points)
+
+		for i := range s.Points {
+			s.Points[i].Lat = cr.round(s.Points[i].Lat)
+			s.Points[i].Lon = cr.round(s.Points[i].Lon)
+		}
+
+		if n := (ShapeRepairer{}).removeDuplicates(s); n > 0 {
+			collapsed += n
+			for i := range s.Points {
+				s.Points[i].Sequence = uint32(i)
+			}
+			ShapeRemeasurer{}.remeasure(s)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d stops, %d shapes rounded, -%d shape points [-%.2f%%])\n",
+		len(feed.Stops),
+		len(feed.Shapes),
+		collapsed,
+		100.0*float64(collapsed)/(float64(numPoints)+0.001))
+}
+
+// Round a single coordinate
+func (cr CoordinateRounder) round(v float32) float32 {
+	f := math.Pow(10, float64(cr.Decimals))
+	return float32(math.Round(float64(v)*f) / f)
+}
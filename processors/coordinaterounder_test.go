@@ -0,0 +1,84 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"math"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+)
+
+func TestDecimalsForMeters(t *testing.T) {
+	tests := map[float64]int{
+		-1:       7,
+		0:        7,
+		0.1:      7,
+		1:        6,
+		11:       5,
+		12:       4,
+		111319.4: 0,
+		1000000:  0,
+	}
+
+	for m, exp := range tests {
+		if d := DecimalsForMeters(m); d != exp {
+			t.Errorf("Expected %d decimals for %f meters, got %d", exp, m, d)
+		}
+	}
+}
+
+func TestCoordinateRounder(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	shp := &gtfs.Shape{Id: "round", Points: gtfs.ShapePoints{
+		{Lat: 0, Lon: 0, Sequence: 0, Dist_traveled: float32(math.NaN())},
+		{Lat: 0.0001, Lon: 0.0001, Sequence: 1, Dist_traveled: float32(math.NaN())},
+		{Lat: 0.0004, Lon: 0, Sequence: 2, Dist_traveled: float32(math.NaN())},
+		{Lat: 1, Lon: 1.0004, Sequence: 3, Dist_traveled: float32(math.NaN())},
+	}}
+	feed.Shapes[shp.Id] = shp
+
+	CoordinateRounder{Decimals: 3}.Run(feed)
+
+	stop := feed.Stops["STAGECOACH"]
+	if !FloatEquals(stop.Lat, 36.916, 1e-6) || !FloatEquals(stop.Lon, -116.752, 1e-6) {
+		t.Error(stop.Lat, stop.Lon)
+	}
+
+	// the first three points collapse into one
+	if len(shp.Points) != 2 {
+		t.Error(shp.Points)
+		return
+	}
+
+	if shp.Points[0].Lat != 0 || shp.Points[0].Lon != 0 || shp.Points[1].Lat != 1 || shp.Points[1].Lon != 1 {
+		t.Error(shp.Points)
+	}
+
+	if shp.Points[0].Sequence != 0 || shp.Points[1].Sequence != 1 {
+		t.Error(shp.Points)
+	}
+
+	// unmeasured shapes are measured in meters
+	d := haversine(0, 0, 1, 1)
+	if !FloatEquals(shp.Points[0].Dist_traveled, 0, 0.001) || !FloatEquals(shp.Points[1].Dist_traveled, float32(d), 1) {
+		t.Error(shp.Points, d)
+	}
+
+	// unaffected shapes keep their points
+	if len(feed.Shapes["A_shp"].Points) < 2 {
+		t.Error(feed.Shapes["A_shp"].Points)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// FareDuplicateRemover cleans up fare_rules.txt and fare_attributes.txt.
+// Fare rules referencing zones no stop belongs to or routes which do not
+// exist are removed. As the contains_id rules of a fare sharing route,
+// origin and destination must all be met, such a group is removed
+// completely if any of its zones does not exist. Fare attributes which
+// lose all their rules this way are removed completely. Zones which are
+// used in exactly the same fare rules are merged, and afterwards, fare
+// attributes with equal values and equal rule sets are merged.
+type FareDuplicateRemover struct {
+}
+
+// Run this FareDuplicateRemover on some feed
+func (fdr FareDuplicateRemover) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Removing redundant fares... ")
+
+	bef := len(feed.FareAttributes)
+	befZones := fdr.countZones(feed)
+
+	fdr.removeDanglingRules(feed)
+	fdr.mergeZones(feed)
+
+	for _, fa := range feed.FareAttributes {
+		fa.Rules = fdr.uniqueRules(fa.Rules)
+	}
+
+	fdr.mergeFares(feed)
+
+	fmt.Fprintf(os.Stdout, "done. (-%d fare attributes [-%.2f%%], -%d zones)\n",
+		bef-len(feed.FareAttributes),
+		100.0*float64(bef-len(feed.FareAttributes))/(float64(bef)+0.001),
+		befZones-fdr.countZones(feed))
+}
+
+// Remove fare rules referencing nonexistent zones or routes
+func (fdr FareDuplicateRemover) removeDanglingRules(feed *gtfsparser.Feed) {
+	zones := make(map[string]bool)
+	for _, s := range feed.Stops {
+		if len(s.Zone_id) > 0 {
+			zones[s.Zone_id] = true
+		}
+	}
+
+	for id, fa := range feed.FareAttributes {
+		if len(fa.Rules) == 0 {
+			continue
+		}
+
+		// contains_id groups with a nonexistent zone can never be met
+		danglingGroups := make(map[string]bool)
+		for _, r := range fa.Rules {
+			if len(r.Contains_id) > 0 && !zones[r.Contains_id] {
+				danglingGroups[fdr.containsGroup(r)] = true
+			}
+		}
+
+		rules := make([]*gtfs.FareAttributeRule, 0, len(fa.Rules))
+		for _, r := range fa.Rules {
+			if r.Route != nil && feed.Routes[r.Route.Id] != r.Route {
+				continue
+			}
+			if (len(r.Origin_id) > 0 && !zones[r.Origin_id]) || (len(r.Destination_id) > 0 && !zones[r.Destination_id]) {
+				continue
+			}
+			if len(r.Contains_id) > 0 && danglingGroups[fdr.containsGroup(r)] {
+				continue
+			}
+			rules = append(rules, r)
+		}
+
+		if len(rules) == 0 {
+			// without rules, the fare would apply to the entire feed
+			feed.DeleteFareAttribute(id)
+			continue
+		}
+
+		fa.Rules = rules
+	}
+
+	feed.ZoneIds = zones
+}
+
+// Return a key identifying the group of contains_id rules a rule belongs to
+func (fdr FareDuplicateRemover) containsGroup(r *gtfs.FareAttributeRule) string {
+	route := ""
+	if r.Route != nil {
+		route = r.Route.Id
+	}
+	return route + "\x00" + r.Origin_id + "\x00" + r.Destination_id
+}
+
+// Merge zones which are used in exactly the same fare rules. Zones used in
+// contains_id rules or in rules leading to themselves are never merged, as
+// merging would change the set of zones a trip passes through.
+func (fdr FareDuplicateRemover) mergeZones(feed *gtfsparser.Feed) {
+	sigs := make(map[string][]string)
+	unmergeable := make(map[string]bool)
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			route := ""
+			if r.Route != nil {
+				route = r.Route.Id
+			}
+
+			if len(r.Contains_id) > 0 {
+				unmergeable[r.Contains_id] = true
+			}
+
+			if len(r.Origin_id) > 0 && r.Origin_id == r.Destination_id {
+				unmergeable[r.Origin_id] = true
+				continue
+			}
+
+			if len(r.Origin_id) > 0 {
+				sigs[r.Origin_id] = append(sigs[r.Origin_id], fa.Id+"\x00"+route+"\x00o\x00"+r.Destination_id+"\x00"+r.Contains_id)
+			}
+			if len(r.Destination_id) > 0 {
+				sigs[r.Destination_id] = append(sigs[r.Destination_id], fa.Id+"\x00"+route+"\x00d\x00"+r.Origin_id+"\x00"+r.Contains_id)
+			}
+		}
+	}
+
+	// map signature to the zone all zones with this signature are merged into
+	refs := make(map[string]string)
+	repl := make(map[string]string)
+
+	zones := make([]string, 0, len(sigs))
+	for z := range sigs {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+
+	for _, z := range zones {
+		if unmergeable[z] {
+			continue
+		}
+
+		sig := sigs[z]
+		sort.Strings(sig)
+		key := strings.Join(sig, "\x01")
+
+		if ref, ok := refs[key]; ok {
+			repl[z] = ref
+		} else {
+			refs[key] = z
+		}
+	}
+
+	if len(repl) == 0 {
+		return
+	}
+
+	for _, s := range feed.Stops {
+		if ref, ok := repl[s.Zone_id]; ok {
+			s.Zone_id = ref
+		}
+	}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			if ref, ok := repl[r.Origin_id]; ok {
+				r.Origin_id = ref
+			}
+			if ref, ok := repl[r.Destination_id]; ok {
+				r.Destination_id = ref
+			}
+		}
+	}
+
+	for z := range repl {
+		delete(feed.ZoneIds, z)
+	}
+}
+
+// Return the rules without duplicates
+func (fdr FareDuplicateRemover) uniqueRules(rules []*gtfs.FareAttributeRule) []*gtfs.FareAttributeRule {
+	seen := make(map[gtfs.FareAttributeRule]bool, len(rules))
+	ret := make([]*gtfs.FareAttributeRule, 0, len(rules))
+
+	for _, r := range rules {
+		if seen[*r] {
+			continue
+		}
+		seen[*r] = true
+		ret = append(ret, r)
+	}
+
+	return ret
+}
+
+// Merge fare attributes with equal values and equal rule sets
+func (fdr FareDuplicateRemover) mergeFares(feed *gtfsparser.Feed) {
+	ids := make([]string, 0, len(feed.FareAttributes))
+	for id := range feed.FareAttributes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	refs := make(map[string]*gtfs.FareAttribute)
+
+	for _, id := range ids {
+		fa := feed.FareAttributes[id]
+		key := fdr.fareKey(fa)
+
+		if _, ok := refs[key]; ok {
+			feed.DeleteFareAttribute(id)
+			continue
+		}
+
+		refs[key] = fa
+	}
+}
+
+// Return a key which is equal for two fare attributes iff they are equivalent
+func (fdr FareDuplicateRemover) fareKey(fa *gtfs.FareAttribute) string {
+	agency := ""
+	if fa.Agency != nil {
+		agency = fa.Agency.Id
+	}
+
+	rules := make([]string, 0, len(fa.Rules))
+	for _, r := range fa.Rules {
+		route := ""
+		if r.Route != nil {
+			route = r.Route.Id
+		}
+		rules = append(rules, route+"\x00"+r.Origin_id+"\x00"+r.Destination_id+"\x00"+r.Contains_id)
+	}
+	sort.Strings(rules)
+
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d\x00%s\x01%s", fa.Price, fa.Currency_type, fa.Payment_method, fa.Transfers, fa.Transfer_duration, agency, strings.Join(rules, "\x01"))
+}
+
+// Return the number of zones used by stops
+func (fdr FareDuplicateRemover) countZones(feed *gtfsparser.Feed) int {
+	zones := make(map[string]bool)
+	for _, s := range feed.Stops {
+		if len(s.Zone_id) > 0 {
+			zones[s.Zone_id] = true
+		}
+	}
+	return len(zones)
+}
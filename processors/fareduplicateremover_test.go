@@ -0,0 +1,140 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestFareDuplicateRemover(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	feed.Stops["STAGECOACH"].Zone_id = "z1"
+	feed.Stops["BEATTY_AIRPORT"].Zone_id = "z2"
+	feed.Stops["NADAV"].Zone_id = "z3"
+	feed.Stops["NANAA"].Zone_id = "z4"
+
+	// a valid contains_id group
+	p := feed.FareAttributes["p"]
+	p.Rules = append(p.Rules, &gtfs.FareAttributeRule{Contains_id: "z1"}, &gtfs.FareAttributeRule{Contains_id: "z2"})
+
+	// a contains_id group with a nonexistent zone, and two origin zones
+	// used in exactly the same rules
+	a := feed.FareAttributes["a"]
+	a.Rules = append(a.Rules,
+		&gtfs.FareAttributeRule{Origin_id: "z1", Contains_id: "z2"},
+		&gtfs.FareAttributeRule{Origin_id: "z1", Contains_id: "ghost"},
+		&gtfs.FareAttributeRule{Origin_id: "z3"},
+		&gtfs.FareAttributeRule{Origin_id: "z4"})
+	numA := len(a.Rules)
+
+	// a fare with only a dangling contains_id group
+	c := &gtfs.FareAttribute{Id: "c", Price: "2.00", Currency_type: "USD", Transfers: -1, Transfer_duration: -1}
+	c.Rules = []*gtfs.FareAttributeRule{{Contains_id: "z2"}, {Contains_id: "ghost"}}
+	feed.FareAttributes[c.Id] = c
+
+	// a duplicate of p
+	d := &gtfs.FareAttribute{Id: "pp", Price: p.Price, Currency_type: p.Currency_type, Payment_method: p.Payment_method, Transfers: p.Transfers, Agency: p.Agency, Transfer_duration: p.Transfer_duration}
+	d.Rules = append(d.Rules, p.Rules...)
+	feed.FareAttributes[d.Id] = d
+
+	proc := FareDuplicateRemover{}
+	proc.Run(feed)
+
+	if _, ok := feed.FareAttributes["c"]; ok {
+		t.Error("Fare c can never apply and should have been removed")
+	}
+
+	if _, ok := feed.FareAttributes["pp"]; ok {
+		t.Error("Fare pp is a duplicate of p")
+	}
+
+	if len(p.Rules) != 5 {
+		t.Errorf("Expected 5 rules for p, got %d", len(p.Rules))
+	}
+
+	// the entire group with origin z1 is dropped, and one of the origin
+	// rules is merged into the other
+	if len(a.Rules) != numA-3 {
+		t.Errorf("Expected %d rules for a, got %d", numA-3, len(a.Rules))
+	}
+
+	for _, r := range a.Rules {
+		if r.Origin_id == "z1" || len(r.Contains_id) > 0 {
+			t.Error("The contains_id group of a should have been removed")
+		}
+		if r.Origin_id == "z4" {
+			t.Error("Zone z4 should have been merged into z3")
+		}
+	}
+
+	if feed.Stops["NANAA"].Zone_id != "z3" {
+		t.Error("Zone z4 should have been merged into z3")
+	}
+
+	if feed.ZoneIds["z4"] || !feed.ZoneIds["z3"] {
+		t.Error("Zone ids were not updated")
+	}
+}
+
+func TestZoneIdMinimization(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	feed.Stops["STAGECOACH"].Zone_id = "zone_one"
+	feed.Stops["BEATTY_AIRPORT"].Zone_id = "zone_two"
+	feed.Stops["NADAV"].Zone_id = "zone_two"
+
+	p := feed.FareAttributes["p"]
+	p.Rules = append(p.Rules, &gtfs.FareAttributeRule{Origin_id: "zone_one", Destination_id: "zone_two", Contains_id: "zone_one"})
+
+	proc := IDMinimizer{Base: 10}
+	proc.minimizeZoneIds(feed)
+
+	one := feed.Stops["STAGECOACH"].Zone_id
+	two := feed.Stops["BEATTY_AIRPORT"].Zone_id
+
+	if one == two || (one != "1" && one != "2") || (two != "1" && two != "2") {
+		t.Errorf("Unexpected zone ids %s and %s", one, two)
+	}
+
+	if feed.Stops["NADAV"].Zone_id != two {
+		t.Error("Stops in the same zone should keep sharing their zone")
+	}
+
+	if len(feed.Stops["NANAA"].Zone_id) != 0 {
+		t.Error("Stops without zone should not get a zone")
+	}
+
+	r := p.Rules[len(p.Rules)-1]
+	if r.Origin_id != one || r.Destination_id != two || r.Contains_id != one {
+		t.Error("Fare rule zones were not updated")
+	}
+
+	if len(feed.ZoneIds) != 2 || !feed.ZoneIds[one] || !feed.ZoneIds[two] {
+		t.Error("Zone ids were not updated")
+	}
+}
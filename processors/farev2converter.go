@@ -0,0 +1,269 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// FareV1ToV2Converter translates fare_attributes.txt and fare_rules.txt into
+// fares v2 files (fare_products.txt, fare_leg_rules.txt, areas.txt,
+// stop_areas.txt, networks.txt, route_networks.txt and
+// fare_transfer_rules.txt), which are written to OutPath. The fares v1 data
+// in the feed is left untouched.
+//
+// Each fare attribute becomes a fare product and a leg group of the same ID,
+// each zone an area, and each route referenced by a fare rule a network
+// containing only this route. Rules without a route of fares restricted to
+// an agency reference a network containing all routes of this agency.
+// Allowed transfers become free transfers within
+// the fare's leg group, with the transfer duration measured from the
+// departure of the first leg. Constructs which cannot be expressed exactly
+// (contains_id rules, prepayment, transfer durations without transfers,
+// routes in both a route and an agency network) are reported on stderr and, if ReportFile is set, written to a CSV file.
+type FareV1ToV2Converter struct {
+	OutPath    string
+	ReportFile string
+}
+
+// A single construct that could not be converted exactly
+type fareConversionIssue struct {
+	fareId string
+	issue  string
+}
+
+// Run this FareV1ToV2Converter on some feed
+func (fc FareV1ToV2Converter) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Converting fares to fares v2... ")
+
+	issues := make([]fareConversionIssue, 0)
+
+	fares := make([]*gtfs.FareAttribute, 0, len(feed.FareAttributes))
+	for _, fa := range feed.FareAttributes {
+		fares = append(fares, fa)
+	}
+
+	sort.Slice(fares, func(i, j int) bool {
+		return fares[i].Id < fares[j].Id
+	})
+
+	products := [][]string{{"fare_product_id", "amount", "currency"}}
+	legRules := [][]string{{"leg_group_id", "network_id", "from_area_id", "to_area_id", "fare_product_id"}}
+	transferRules := [][]string{{"from_leg_group_id", "to_leg_group_id", "transfer_count", "duration_limit", "duration_limit_type", "fare_transfer_type"}}
+
+	networks := make(map[*gtfs.Route]bool)
+	agencyNets := make(map[*gtfs.Agency]string)
+	agencyNetFares := make(map[*gtfs.Agency][]string)
+	legRulesSeen := make(map[[4]string]bool)
+
+	for _, fa := range fares {
+		products = append(products, []string{fa.Id, fa.Price, fa.Currency_type})
+
+		if fa.Payment_method == 1 {
+			issues = append(issues, fareConversionIssue{fa.Id, "payment before boarding cannot be expressed without fare media"})
+		}
+
+		// network for rules without a route, empty if the fare applies to
+		// all agencies
+		defNetworkId := ""
+		if fa.Agency != nil {
+			if _, ok := agencyNets[fa.Agency]; !ok {
+				agencyNets[fa.Agency] = fc.agencyNetworkId(feed, fa.Agency)
+			}
+			defNetworkId = agencyNets[fa.Agency]
+			agencyNetFares[fa.Agency] = append(agencyNetFares[fa.Agency], fa.Id)
+		}
+
+		if len(fa.Rules) == 0 {
+			// fare applies to all legs
+			legRules = append(legRules, []string{fa.Id, defNetworkId, "", "", fa.Id})
+		}
+
+		hasLegRule := false
+
+		for _, r := range fa.Rules {
+			if len(r.Contains_id) > 0 {
+				issues = append(issues, fareConversionIssue{fa.Id, "rule with contains_id '" + r.Contains_id + "' cannot be expressed, skipped"})
+				continue
+			}
+
+			networkId := defNetworkId
+			if r.Route != nil {
+				networkId = r.Route.Id
+				networks[r.Route] = true
+			}
+
+			hasLegRule = true
+
+			key := [4]string{fa.Id, networkId, r.Origin_id, r.Destination_id}
+			if legRulesSeen[key] {
+				continue
+			}
+			legRulesSeen[key] = true
+
+			legRules = append(legRules, []string{fa.Id, networkId, r.Origin_id, r.Destination_id, fa.Id})
+		}
+
+		if len(fa.Rules) > 0 && !hasLegRule {
+			issues = append(issues, fareConversionIssue{fa.Id, "all rules use contains_id, fare product is not applied to any leg"})
+		}
+
+		if fa.Transfers == 0 {
+			if fa.Transfer_duration > 0 {
+				issues = append(issues, fareConversionIssue{fa.Id, "transfer_duration without allowed transfers cannot be expressed, skipped"})
+			}
+			continue
+		}
+
+		duration := ""
+		durationType := ""
+		if fa.Transfer_duration > 0 {
+			duration = strconv.Itoa(fa.Transfer_duration)
+			durationType = "1"
+		}
+
+		transferRules = append(transferRules, []string{fa.Id, fa.Id, strconv.Itoa(fa.Transfers), duration, durationType, "0"})
+	}
+
+	areas := [][]string{{"area_id", "area_name"}}
+	zones := make([]string, 0, len(feed.ZoneIds))
+	for z := range feed.ZoneIds {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	for _, z := range zones {
+		areas = append(areas, []string{z, ""})
+	}
+
+	stopAreas := [][]string{{"area_id", "stop_id"}}
+	for _, s := range feed.Stops {
+		if len(s.Zone_id) > 0 {
+			stopAreas = append(stopAreas, []string{s.Zone_id, s.Id})
+		}
+	}
+	sort.Slice(stopAreas[1:], func(i, j int) bool {
+		a := stopAreas[i+1]
+		b := stopAreas[j+1]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		return a[1] < b[1]
+	})
+
+	netRows := [][]string{{"network_id", "network_name"}}
+	routeNetRows := [][]string{{"network_id", "route_id"}}
+	for r := range networks {
+		netRows = append(netRows, []string{r.Id, r.Short_name})
+		routeNetRows = append(routeNetRows, []string{r.Id, r.Id})
+	}
+
+	for a, networkId := range agencyNets {
+		netRows = append(netRows, []string{networkId, a.Name})
+		for _, r := range feed.Routes {
+			if r.Agency != a {
+				continue
+			}
+			if networks[r] {
+				// a route can only belong to a single network
+				for _, fareId := range agencyNetFares[a] {
+					issues = append(issues, fareConversionIssue{fareId, "route '" + r.Id + "' of agency '" + a.Id + "' is already in its own network, fare does not apply to it"})
+				}
+				continue
+			}
+			routeNetRows = append(routeNetRows, []string{networkId, r.Id})
+		}
+	}
+
+	sort.Slice(netRows[1:], func(i, j int) bool { return netRows[i+1][0] < netRows[j+1][0] })
+	sort.Slice(routeNetRows[1:], func(i, j int) bool {
+		a := routeNetRows[i+1]
+		b := routeNetRows[j+1]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		return a[1] < b[1]
+	})
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].fareId < issues[j].fareId })
+
+	files := map[string][][]string{
+		"fare_products.txt":       products,
+		"fare_leg_rules.txt":      legRules,
+		"fare_transfer_rules.txt": transferRules,
+		"areas.txt":               areas,
+		"stop_areas.txt":          stopAreas,
+		"networks.txt":            netRows,
+		"route_networks.txt":      routeNetRows,
+	}
+
+	if err := os.MkdirAll(fc.OutPath, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create fares v2 output directory %s: %s\n", fc.OutPath, err.Error())
+	} else {
+		for name, rows := range files {
+			if len(rows) < 2 {
+				continue
+			}
+			if err := fc.writeCSV(filepath.Join(fc.OutPath, name), rows); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not write %s: %s\n", name, err.Error())
+			}
+		}
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "Fare '%s': %s\n", issue.fareId, issue.issue)
+	}
+
+	if len(fc.ReportFile) > 0 {
+		rows := [][]string{{"fare_id", "issue"}}
+		for _, issue := range issues {
+			rows = append(rows, []string{issue.fareId, issue.issue})
+		}
+		if err := fc.writeCSV(fc.ReportFile, rows); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write fare conversion report to %s: %s\n", fc.ReportFile, err.Error())
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d fare products, %d fare leg rules, %d fare transfer rules, %d constructs not converted exactly)\n",
+		len(products)-1,
+		len(legRules)-1,
+		len(transferRules)-1,
+		len(issues))
+}
+
+// Return a network ID for all routes of an agency which does not collide
+// with the route networks
+func (fc FareV1ToV2Converter) agencyNetworkId(feed *gtfsparser.Feed, a *gtfs.Agency) string {
+	for try := 0; ; try++ {
+		id := "agency::" + a.Id
+		if try > 0 {
+			id = "agency" + strconv.Itoa(try) + "::" + a.Id
+		}
+		if _, ok := feed.Routes[id]; !ok {
+			return id
+		}
+	}
+}
+
+func (fc FareV1ToV2Converter) writeCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+
+	return w.Error()
+}
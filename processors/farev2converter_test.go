@@ -0,0 +1,143 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+)
+
+func readTestCSV(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestFareV1ToV2Converter(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dir := t.TempDir()
+	fc := FareV1ToV2Converter{OutPath: dir, ReportFile: filepath.Join(dir, "report.csv")}
+	fc.Run(feed)
+
+	products := readTestCSV(t, filepath.Join(dir, "fare_products.txt"))
+	if len(products) != 3 || products[1][0] != "a" || products[1][1] != "5.25" || products[2][0] != "p" {
+		t.Error(products)
+	}
+
+	legRules := readTestCSV(t, filepath.Join(dir, "fare_leg_rules.txt"))
+	if len(legRules) != 12 {
+		t.Error(legRules)
+	}
+
+	found := false
+	for _, r := range legRules[1:] {
+		if r[0] == "p" && r[1] == "STBA" && r[4] == "p" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("missing leg rule for route STBA")
+	}
+
+	routeNets := readTestCSV(t, filepath.Join(dir, "route_networks.txt"))
+	if len(routeNets) != 12 {
+		t.Error(routeNets)
+	}
+
+	// no transfers allowed in the test feed
+	if _, err := os.Stat(filepath.Join(dir, "fare_transfer_rules.txt")); err == nil {
+		t.Error("expected no fare transfer rules")
+	}
+
+	report := readTestCSV(t, filepath.Join(dir, "report.csv"))
+	if len(report) != 1 {
+		t.Error(report)
+	}
+}
+
+func TestFareV1ToV2ConverterAgencies(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	other := &gtfs.Agency{Id: "OTHER", Name: "Other Agency"}
+	feed.Agencies[other.Id] = other
+	feed.Routes["CITY"].Agency = other
+	feed.Routes["STBA"].Agency = other
+
+	// flat fare of the other agency, STBA is also referenced by fare p
+	feed.FareAttributes["b"] = &gtfs.FareAttribute{Id: "b", Price: "2.00", Currency_type: "USD", Agency: other}
+
+	// fare which only has contains_id rules
+	feed.FareAttributes["c"] = &gtfs.FareAttribute{Id: "c", Price: "3.00", Currency_type: "USD", Rules: []*gtfs.FareAttributeRule{{Contains_id: "Z"}}}
+
+	dir := t.TempDir()
+	fc := FareV1ToV2Converter{OutPath: dir, ReportFile: filepath.Join(dir, "report.csv")}
+	fc.Run(feed)
+
+	found := false
+	for _, r := range readTestCSV(t, filepath.Join(dir, "fare_leg_rules.txt"))[1:] {
+		if r[0] == "b" {
+			found = r[1] == "agency::OTHER"
+		}
+		if r[0] == "c" {
+			t.Error("unexpected leg rule for fare c")
+		}
+	}
+	if !found {
+		t.Error("missing leg rule for the network of agency OTHER")
+	}
+
+	found = false
+	for _, r := range readTestCSV(t, filepath.Join(dir, "networks.txt"))[1:] {
+		if r[0] == "agency::OTHER" && r[1] == "Other Agency" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("missing network for agency OTHER")
+	}
+
+	agencyRoutes := make([]string, 0)
+	for _, r := range readTestCSV(t, filepath.Join(dir, "route_networks.txt"))[1:] {
+		if r[0] == "agency::OTHER" {
+			agencyRoutes = append(agencyRoutes, r[1])
+		}
+	}
+	if len(agencyRoutes) != 1 || agencyRoutes[0] != "CITY" {
+		t.Error(agencyRoutes)
+	}
+
+	report := readTestCSV(t, filepath.Join(dir, "report.csv"))
+	if len(report) != 4 || report[1][0] != "b" || report[2][0] != "c" || report[3][0] != "c" {
+		t.Error(report)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	mail "net/mail"
+	url "net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// FeedInfoUpdater maintains feed_info.txt. If MergeInfos is set, multiple
+// feed_info entries (for example from merged feeds) are merged into a single
+// one, otherwise all entries are updated. If no entry exists, it is created
+// from the given publisher name, URL and language.
+// Optionally, feed_start_date and feed_end_date are recomputed from the
+// services actually used by trips, and feed_version is set from a template.
+//
+// The version template may contain the placeholders {date} (the current
+// date), {start} and {end} (the feed dates), {hash} (a hash of the feed
+// content) and {n}, a counter which is incremented if the previous
+// feed_version matches the template, and otherwise starts at 1.
+type FeedInfoUpdater struct {
+	MergeInfos    bool
+	UpdateDates   bool
+	VersionTmpl   string
+	PublisherName string
+	PublisherUrl  *url.URL
+	Lang          *gtfs.LanguageISO6391
+	ContactEmail  *mail.Address
+	ContactUrl    *url.URL
+}
+
+// Run this FeedInfoUpdater on some feed
+func (fu FeedInfoUpdater) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Updating feed info... ")
+
+	bef := len(feed.FeedInfos)
+	prevVersions := make([]string, 0)
+	for _, fi := range feed.FeedInfos {
+		if len(fi.Version) > 0 {
+			prevVersions = append(prevVersions, fi.Version)
+		}
+	}
+
+	if fu.MergeInfos && len(feed.FeedInfos) > 1 {
+		fu.mergeFeedInfos(feed)
+	}
+
+	if len(feed.FeedInfos) == 0 {
+		if len(fu.PublisherName) == 0 {
+			fmt.Fprintf(os.Stdout, "done. (no feed info present)\n")
+			return
+		}
+
+		if fu.PublisherUrl == nil || fu.Lang == nil {
+			fmt.Fprintf(os.Stdout, "done.\n")
+			fmt.Fprintf(os.Stderr, "Could not create feed info, publisher name, publisher URL and language are required.\n")
+			return
+		}
+
+		feed.FeedInfos = append(feed.FeedInfos, &gtfs.FeedInfo{})
+	}
+
+	for _, fi := range feed.FeedInfos {
+		fu.update(feed, fi, prevVersions)
+	}
+
+	fi := feed.FeedInfos[0]
+
+	fmt.Fprintf(os.Stdout, "done. (-%d feed infos, feed_version '%s', %s - %s)\n",
+		imax(0, bef-len(feed.FeedInfos)),
+		fi.Version,
+		fu.dateString(fi.Start_date),
+		fu.dateString(fi.End_date))
+}
+
+// Update a single feed info
+func (fu FeedInfoUpdater) update(feed *gtfsparser.Feed, fi *gtfs.FeedInfo, prevVersions []string) {
+	if len(fu.PublisherName) > 0 {
+		fi.Publisher_name = fu.PublisherName
+	}
+	if fu.PublisherUrl != nil {
+		fi.Publisher_url = fu.PublisherUrl
+	}
+	if fu.Lang != nil {
+		fi.Lang = *fu.Lang
+	}
+	if fu.ContactEmail != nil {
+		fi.Contact_email = fu.ContactEmail
+	}
+	if fu.ContactUrl != nil {
+		fi.Contact_url = fu.ContactUrl
+	}
+
+	if fu.UpdateDates {
+		fi.Start_date, fi.End_date = fu.getFeedDates(feed)
+	}
+
+	if len(fu.VersionTmpl) > 0 {
+		fi.Version = fu.getVersion(feed, fi, prevVersions)
+	}
+}
+
+// Merge all feed infos into the first one
+func (fu FeedInfoUpdater) mergeFeedInfos(feed *gtfsparser.Feed) {
+	ref := feed.FeedInfos[0]
+
+	names := make([]string, 0, len(feed.FeedInfos))
+	versions := make([]string, 0, len(feed.FeedInfos))
+	hasName := make(map[string]bool, len(feed.FeedInfos))
+	hasVersion := make(map[string]bool, len(feed.FeedInfos))
+
+	for i, fi := range feed.FeedInfos {
+		if len(fi.Publisher_name) > 0 && !hasName[fi.Publisher_name] {
+			hasName[fi.Publisher_name] = true
+			names = append(names, fi.Publisher_name)
+		}
+
+		if len(fi.Version) > 0 && !hasVersion[fi.Version] {
+			hasVersion[fi.Version] = true
+			versions = append(versions, fi.Version)
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		if ref.Start_date.IsEmpty() || (!fi.Start_date.IsEmpty() && fi.Start_date.GetTime().Before(ref.Start_date.GetTime())) {
+			ref.Start_date = fi.Start_date
+		}
+
+		if ref.End_date.IsEmpty() || (!fi.End_date.IsEmpty() && fi.End_date.GetTime().After(ref.End_date.GetTime())) {
+			ref.End_date = fi.End_date
+		}
+
+		if ref.Contact_email == nil {
+			ref.Contact_email = fi.Contact_email
+		}
+
+		if ref.Contact_url == nil {
+			ref.Contact_url = fi.Contact_url
+		}
+
+		for k := range feed.FeedInfosAddFlds {
+			if _, ok := feed.FeedInfosAddFlds[k][ref]; !ok {
+				if v, ok := feed.FeedInfosAddFlds[k][fi]; ok {
+					feed.FeedInfosAddFlds[k][ref] = v
+				}
+			}
+			delete(feed.FeedInfosAddFlds[k], fi)
+		}
+	}
+
+	ref.Publisher_name = strings.Join(names, ", ")
+	ref.Version = strings.Join(versions, "+")
+
+	feed.FeedInfos = feed.FeedInfos[:1]
+}
+
+// Return the first and last date any trip is active on
+func (fu FeedInfoUpdater) getFeedDates(feed *gtfsparser.Feed) (gtfs.Date, gtfs.Date) {
+	var start, end gtfs.Date
+
+	done := make(map[*gtfs.Service]bool)
+
+	for _, t := range feed.Trips {
+		if done[t.Service] {
+			continue
+		}
+		done[t.Service] = true
+
+		first := t.Service.GetFirstActiveDate()
+		last := t.Service.GetLastActiveDate()
+
+		if first.IsEmpty() || last.IsEmpty() {
+			continue
+		}
+
+		if start.IsEmpty() || first.GetTime().Before(start.GetTime()) {
+			start = first
+		}
+
+		if end.IsEmpty() || last.GetTime().After(end.GetTime()) {
+			end = last
+		}
+	}
+
+	return start, end
+}
+
+// Return the feed version from the version template
+func (fu FeedInfoUpdater) getVersion(feed *gtfsparser.Feed, fi *gtfs.FeedInfo, prevVersions []string) string {
+	ver := fu.VersionTmpl
+	ver = strings.ReplaceAll(ver, "{date}", fu.dateString(gtfs.GetGtfsDateFromTime(time.Now())))
+	ver = strings.ReplaceAll(ver, "{start}", fu.dateString(fi.Start_date))
+	ver = strings.ReplaceAll(ver, "{end}", fu.dateString(fi.End_date))
+
+	if strings.Contains(ver, "{hash}") {
+		ver = strings.ReplaceAll(ver, "{hash}", fu.contentHash(feed))
+	}
+
+	if !strings.Contains(ver, "{n}") {
+		return ver
+	}
+
+	parts := strings.Split(ver, "{n}")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+
+	n := 1
+	re := regexp.MustCompile("^" + strings.Join(parts, "([0-9]+)") + "$")
+
+	for _, prev := range prevVersions {
+		m := re.FindStringSubmatch(prev)
+		if m == nil {
+			continue
+		}
+		if prevN, err := strconv.Atoi(m[1]); err == nil && prevN+1 > n {
+			n = prevN + 1
+		}
+	}
+
+	return strings.ReplaceAll(ver, "{n}", strconv.Itoa(n))
+}
+
+// Return a hash over the stops, routes, trips, services and shapes
+func (fu FeedInfoUpdater) contentHash(feed *gtfsparser.Feed) string {
+	h := sha256.New()
+
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops))
+	for _, s := range feed.Stops {
+		stops = append(stops, s)
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+	for _, s := range stops {
+		fmt.Fprintf(h, "s%s|%s|%f|%f|%d\n", s.Id, s.Name, s.Lat, s.Lon, s.Location_type)
+	}
+
+	routes := make([]*gtfs.Route, 0, len(feed.Routes))
+	for _, r := range feed.Routes {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Id < routes[j].Id })
+	for _, r := range routes {
+		fmt.Fprintf(h, "r%s|%s|%s|%d\n", r.Id, r.Short_name, r.Long_name, r.Type)
+	}
+
+	services := make([]*gtfs.Service, 0, len(feed.Services))
+	for _, s := range feed.Services {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Id() < services[j].Id() })
+	for _, s := range services {
+		exceptions := make([]string, 0, len(s.Exceptions()))
+		for d, v := range s.Exceptions() {
+			exceptions = append(exceptions, fu.dateString(d)+strconv.FormatBool(v))
+		}
+		sort.Strings(exceptions)
+		fmt.Fprintf(h, "c%s|%s|%s|%d|%s\n", s.Id(), fu.dateString(s.Start_date()), fu.dateString(s.End_date()), s.RawDaymap(), strings.Join(exceptions, ","))
+	}
+
+	trips := make([]*gtfs.Trip, 0, len(feed.Trips))
+	for _, t := range feed.Trips {
+		trips = append(trips, t)
+	}
+	sort.Slice(trips, func(i, j int) bool { return trips[i].Id < trips[j].Id })
+	for _, t := range trips {
+		fmt.Fprintf(h, "t%s|%s|%s\n", t.Id, t.Route.Id, t.Service.Id())
+		for _, st := range t.StopTimes {
+			fmt.Fprintf(h, "%s|%d|%d\n", st.Stop().Id, st.Arrival_time().SecondsSinceMidnight(), st.Departure_time().SecondsSinceMidnight())
+		}
+	}
+
+	shapes := make([]*gtfs.Shape, 0, len(feed.Shapes))
+	for _, s := range feed.Shapes {
+		shapes = append(shapes, s)
+	}
+	sort.Slice(shapes, func(i, j int) bool { return shapes[i].Id < shapes[j].Id })
+	for _, s := range shapes {
+		fmt.Fprintf(h, "p%s\n", s.Id)
+		for _, p := range s.Points {
+			fmt.Fprintf(h, "%f|%f\n", p.Lat, p.Lon)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Return a date as YYYYMMDD, or an empty string for empty dates
+func (fu FeedInfoUpdater) dateString(d gtfs.Date) string {
+	if d.IsEmpty() {
+		return ""
+	}
+	return fmt.Sprintf("%04d%02d%02d", d.Year(), d.Month(), d.Day())
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func testFeedInfos() []*gtfs.FeedInfo {
+	return []*gtfs.FeedInfo{
+		{Publisher_name: "A", Version: "v1", Start_date: gtfs.NewDate(1, 1, 2024), End_date: gtfs.NewDate(30, 6, 2024)},
+		{Publisher_name: "", Version: ""},
+		{Publisher_name: "B", Version: "v2", Start_date: gtfs.NewDate(1, 12, 2023), End_date: gtfs.NewDate(31, 12, 2024)},
+		{Publisher_name: "A", Version: "v1"},
+	}
+}
+
+func TestFeedInfoMerge(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	feed.FeedInfos = testFeedInfos()
+
+	FeedInfoUpdater{MergeInfos: true}.Run(feed)
+
+	if len(feed.FeedInfos) != 1 {
+		t.Errorf("Expected 1 feed info, got %d", len(feed.FeedInfos))
+		return
+	}
+
+	fi := feed.FeedInfos[0]
+
+	if fi.Publisher_name != "A, B" {
+		t.Errorf("Unexpected publisher name '%s'", fi.Publisher_name)
+	}
+
+	if fi.Version != "v1+v2" {
+		t.Errorf("Unexpected feed version '%s'", fi.Version)
+	}
+
+	if fi.Start_date != gtfs.NewDate(1, 12, 2023) || fi.End_date != gtfs.NewDate(31, 12, 2024) {
+		t.Error("Merged feed info should cover the dates of all feed infos")
+	}
+
+	// without merging, all feed infos are kept and updated
+	feed.FeedInfos = testFeedInfos()
+
+	FeedInfoUpdater{PublisherName: "C"}.Run(feed)
+
+	if len(feed.FeedInfos) != 4 {
+		t.Errorf("Expected 4 feed infos, got %d", len(feed.FeedInfos))
+		return
+	}
+
+	for _, fi := range feed.FeedInfos {
+		if fi.Publisher_name != "C" {
+			t.Errorf("Unexpected publisher name '%s'", fi.Publisher_name)
+		}
+	}
+}
+
+func TestFeedInfoUpdater(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	// no feed info is created without publisher URL and language
+	FeedInfoUpdater{PublisherName: "X"}.Run(feed)
+
+	if len(feed.FeedInfos) != 0 {
+		t.Error("Feed info should not have been created")
+		return
+	}
+
+	feed.FeedInfos = append(feed.FeedInfos, &gtfs.FeedInfo{Publisher_name: "X", Version: "release-3"})
+
+	FeedInfoUpdater{UpdateDates: true, VersionTmpl: "release-{n}"}.Run(feed)
+
+	fi := feed.FeedInfos[0]
+
+	if fi.Start_date != gtfs.NewDate(1, 1, 2007) || fi.End_date != gtfs.NewDate(5, 11, 2017) {
+		t.Errorf("Unexpected feed dates %d-%d-%d - %d-%d-%d", fi.Start_date.Year(), fi.Start_date.Month(), fi.Start_date.Day(), fi.End_date.Year(), fi.End_date.Month(), fi.End_date.Day())
+	}
+
+	if fi.Version != "release-4" {
+		t.Errorf("Unexpected feed version '%s'", fi.Version)
+	}
+
+	FeedInfoUpdater{VersionTmpl: "{start}-{end}"}.Run(feed)
+
+	if fi.Version != "20070101-20171105" {
+		t.Errorf("Unexpected feed version '%s'", fi.Version)
+	}
+}
@@ -63,9 +63,18 @@ func (m FrequencyMinimizer) Run(feed *gtfsparser.Feed) {
 	}
 	tripsBef := len(feed.Trips)
 
+	// trips referenced by trip-scoped transfers must be kept as they are
+	for t := range getTripTransfers(feed) {
+		processed[t] = empty{}
+	}
+
 	// build a slice of trips for parallel processing
 	tripsSl := make(map[*gtfs.Route]map[*gtfs.Service][]*gtfs.Trip, 0)
 	for _, t := range feed.Trips {
+		if _, ok := processed[t]; ok {
+			continue
+		}
+
 		if _, in := tripsSl[t.Route]; !in {
 			tripsSl[t.Route] = make(map[*gtfs.Service][]*gtfs.Trip, 1)
 		}
@@ -0,0 +1,225 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// "GPKG" application ID and version 1.2 of GeoPackage files
+const gpkgAppId = 0x47504B47
+const gpkgVersion = 10200
+
+const gpkgWgs84 = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`
+
+// A feature table of a GeoPackage, geomType is either POINT or LINESTRING
+type gpkgLayer struct {
+	name     string
+	geomType string
+	fc       *geojson.FeatureCollection
+}
+
+// Write layers as feature tables in WGS84 to a GeoPackage file at path.
+// Feature properties become columns, lists are joined by commas.
+func writeGpkg(path string, layers []gpkgLayer) error {
+	db := newSqliteDb()
+
+	db.addTable("gpkg_spatial_ref_sys", "CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER NOT NULL PRIMARY KEY, organization TEXT NOT NULL, organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT)",
+		[]sqliteRow{
+			{-1, []interface{}{"Undefined cartesian SRS", nil, "NONE", int64(-1), "undefined", "undefined cartesian coordinate reference system"}},
+			{0, []interface{}{"Undefined geographic SRS", nil, "NONE", int64(0), "undefined", "undefined geographic coordinate reference system"}},
+			{4326, []interface{}{"WGS 84 geodetic", nil, "EPSG", int64(4326), gpkgWgs84, "longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid"}},
+		})
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
+	contents := make([]sqliteRow, 0, len(layers))
+	geomCols := make([]sqliteRow, 0, len(layers))
+	contentKeys := make([][]interface{}, 0, len(layers))
+	contentIdKeys := make([][]interface{}, 0, len(layers))
+	geomColKeys := make([][]interface{}, 0, len(layers))
+	geomTblKeys := make([][]interface{}, 0, len(layers))
+
+	for i, l := range layers {
+		rowid := int64(i + 1)
+
+		bbox := gpkgBbox(l.fc)
+		contents = append(contents, sqliteRow{rowid, []interface{}{l.name, "features", l.name, "", now, bbox[0], bbox[1], bbox[2], bbox[3], int64(4326)}})
+		geomCols = append(geomCols, sqliteRow{rowid, []interface{}{l.name, "geom", l.geomType, int64(4326), int64(0), int64(0)}})
+
+		contentKeys = append(contentKeys, []interface{}{l.name, rowid})
+		contentIdKeys = append(contentIdKeys, []interface{}{l.name, rowid})
+		geomColKeys = append(geomColKeys, []interface{}{l.name, "geom", rowid})
+		geomTblKeys = append(geomTblKeys, []interface{}{l.name, rowid})
+	}
+
+	db.addTable("gpkg_contents", "CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE, description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')), min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER, CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))", contents)
+
+	if err := db.addAutoIndex("sqlite_autoindex_gpkg_contents_1", "gpkg_contents", contentKeys); err != nil {
+		return err
+	}
+	if err := db.addAutoIndex("sqlite_autoindex_gpkg_contents_2", "gpkg_contents", contentIdKeys); err != nil {
+		return err
+	}
+
+	db.addTable("gpkg_geometry_columns", "CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, geometry_type_name TEXT NOT NULL, srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name), CONSTRAINT uk_gc_table_name UNIQUE (table_name), CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name), CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))", geomCols)
+
+	if err := db.addAutoIndex("sqlite_autoindex_gpkg_geometry_columns_1", "gpkg_geometry_columns", geomColKeys); err != nil {
+		return err
+	}
+	if err := db.addAutoIndex("sqlite_autoindex_gpkg_geometry_columns_2", "gpkg_geometry_columns", geomTblKeys); err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		sql, rows := gpkgFeatureTable(l)
+		db.addTable(l.name, sql, rows)
+	}
+
+	return db.write(path, gpkgAppId, gpkgVersion)
+}
+
+// Return the CREATE statement and the rows of a layer's feature table
+func gpkgFeatureTable(l gpkgLayer) (string, []sqliteRow) {
+	types := make(map[string]string)
+	for _, f := range l.fc.Features {
+		for k, v := range f.Properties {
+			if _, ok := types[k]; !ok {
+				types[k] = gpkgColType(v)
+			}
+		}
+	}
+
+	cols := make([]string, 0, len(types))
+	for k := range types {
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+
+	defs := []string{"fid INTEGER PRIMARY KEY NOT NULL", "geom " + l.geomType}
+	for _, c := range cols {
+		defs = append(defs, gpkgQuote(c)+" "+types[c])
+	}
+
+	rows := make([]sqliteRow, len(l.fc.Features))
+	for i, f := range l.fc.Features {
+		vals := []interface{}{nil, gpkgGeom(f.Geometry)}
+		for _, c := range cols {
+			vals = append(vals, gpkgValue(f.Properties[c]))
+		}
+		rows[i] = sqliteRow{int64(i + 1), vals}
+	}
+
+	return "CREATE TABLE " + gpkgQuote(l.name) + " (" + strings.Join(defs, ", ") + ")", rows
+}
+
+// Return the column type for a property value
+func gpkgColType(v interface{}) string {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER"
+	case reflect.Float32, reflect.Float64:
+		return "DOUBLE"
+	default:
+		return "TEXT"
+	}
+}
+
+// Return a property value as an SQLite value
+func gpkgValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice:
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(rv.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Encode a point or line string as a GeoPackage geometry blob
+func gpkgGeom(g *geojson.Geometry) []byte {
+	buf := new(bytes.Buffer)
+
+	// magic, version 0, little endian without envelope
+	buf.Write([]byte{'G', 'P', 0, 1})
+	binary.Write(buf, binary.LittleEndian, int32(4326))
+
+	// WKB, little endian
+	buf.WriteByte(1)
+	switch {
+	case g.IsPoint():
+		binary.Write(buf, binary.LittleEndian, uint32(1))
+		binary.Write(buf, binary.LittleEndian, g.Point[:2])
+	case g.IsLineString():
+		binary.Write(buf, binary.LittleEndian, uint32(2))
+		binary.Write(buf, binary.LittleEndian, uint32(len(g.LineString)))
+		for _, p := range g.LineString {
+			binary.Write(buf, binary.LittleEndian, p[:2])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Return the bounding box (min x, min y, max x, max y) of all features,
+// or nil values if there are none
+func gpkgBbox(fc *geojson.FeatureCollection) [4]interface{} {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+
+	add := func(p []float64) {
+		minX = math.Min(minX, p[0])
+		minY = math.Min(minY, p[1])
+		maxX = math.Max(maxX, p[0])
+		maxY = math.Max(maxY, p[1])
+	}
+
+	for _, f := range fc.Features {
+		if f.Geometry.IsPoint() {
+			add(f.Geometry.Point)
+		}
+		for _, p := range f.Geometry.LineString {
+			add(p)
+		}
+	}
+
+	if minX > maxX {
+		return [4]interface{}{nil, nil, nil, nil}
+	}
+
+	return [4]interface{}{minX, minY, maxX, maxY}
+}
+
+// Quote an SQL identifier
+func gpkgQuote(id string) string {
+	return `"` + strings.ReplaceAll(id, `"`, `""`) + `"`
+}
@@ -29,6 +29,7 @@ type IDMinimizer struct {
 	KeepAgencies     bool
 	KeepPathways     bool
 	KeepAttributions bool
+	KeepZones        bool
 }
 
 // Run this IDMinimizer on a feed
@@ -65,6 +66,13 @@ func (minimizer IDMinimizer) Run(feed *gtfsparser.Feed) {
 		j = j - 1
 	}
 	fmt.Fprintf(os.Stdout, "Minimizing ids... ")
+
+	// zone IDs are referenced by both stops and fare rules, minimize them
+	// before stops and fares are processed concurrently
+	if !minimizer.KeepZones {
+		minimizer.minimizeZoneIds(feed)
+	}
+
 	sem := make(chan empty, j)
 
 	if !minimizer.KeepTrips {
@@ -324,6 +332,40 @@ func (minimizer IDMinimizer) minimizeFareIds(feed *gtfsparser.Feed) {
 	feed.FareAttributes = newMap
 }
 
+// Minimize zone IDs
+func (minimizer IDMinimizer) minimizeZoneIds(feed *gtfsparser.Feed) {
+	var idCount int64 = 1
+
+	newIds := make(map[string]string)
+	getNewId := func(oldId string) string {
+		if len(oldId) == 0 {
+			return oldId
+		}
+		if _, ok := newIds[oldId]; !ok {
+			newIds[oldId] = minimizer.Prefix + strconv.FormatInt(idCount, minimizer.Base)
+			idCount = idCount + 1
+		}
+		return newIds[oldId]
+	}
+
+	for _, s := range feed.Stops {
+		s.Zone_id = getNewId(s.Zone_id)
+	}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			r.Origin_id = getNewId(r.Origin_id)
+			r.Destination_id = getNewId(r.Destination_id)
+			r.Contains_id = getNewId(r.Contains_id)
+		}
+	}
+
+	feed.ZoneIds = make(map[string]bool, len(newIds))
+	for _, newId := range newIds {
+		feed.ZoneIds[newId] = true
+	}
+}
+
 // Minimize pathway IDs
 func (minimizer IDMinimizer) minimizePathwayIds(feed *gtfsparser.Feed) {
 	var idCount int64 = 1
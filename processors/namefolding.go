@@ -0,0 +1,123 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// built-in transliterations of lower case characters which are not
+// handled by diacritic folding, including Cyrillic and Greek letters
+var builtinTranslit = map[string]string{
+	// Latin
+	"ß": "ss", "æ": "ae", "œ": "oe", "ø": "o", "ł": "l", "đ": "d", "ð": "d", "þ": "th", "ı": "i",
+
+	// Cyrillic
+	"а": "a", "б": "b", "в": "v", "г": "g", "д": "d", "е": "e", "ё": "e", "ж": "zh",
+	"з": "z", "и": "i", "й": "y", "к": "k", "л": "l", "м": "m", "н": "n", "о": "o",
+	"п": "p", "р": "r", "с": "s", "т": "t", "у": "u", "ф": "f", "х": "kh", "ц": "ts",
+	"ч": "ch", "ш": "sh", "щ": "shch", "ъ": "", "ы": "y", "ь": "", "э": "e", "ю": "yu",
+	"я": "ya", "і": "i", "ї": "yi", "є": "ye", "ґ": "g", "ђ": "dj", "ј": "j", "љ": "lj",
+	"њ": "nj", "ћ": "c", "џ": "dz", "ў": "u",
+
+	// Greek
+	"α": "a", "β": "v", "γ": "g", "δ": "d", "ε": "e", "ζ": "z", "η": "i", "θ": "th",
+	"ι": "i", "κ": "k", "λ": "l", "μ": "m", "ν": "n", "ξ": "x", "ο": "o", "π": "p",
+	"ρ": "r", "σ": "s", "ς": "s", "τ": "t", "υ": "y", "φ": "f", "χ": "ch", "ψ": "ps",
+	"ω": "o",
+}
+
+// built-in stop word lists, per language
+var builtinStopWords = map[string][]string{
+	"de": {"am", "an", "auf", "bei", "das", "dem", "den", "der", "des", "die", "im", "in", "und", "von", "vor", "zum", "zur"},
+	"en": {"and", "at", "by", "of", "on", "the", "to"},
+	"es": {"de", "del", "el", "la", "las", "los", "y"},
+	"fr": {"a", "au", "aux", "d", "de", "des", "du", "et", "l", "la", "le", "les", "sous", "sur"},
+	"it": {"al", "alla", "da", "dei", "del", "della", "di", "e"},
+	"nl": {"aan", "bij", "de", "en", "het", "op", "van"},
+}
+
+// A NameFolder folds names into a canonical representation used for
+// comparison: case and diacritics are removed, and characters are
+// transliterated into Latin script
+type NameFolder struct {
+	userRepl    *strings.Replacer
+	builtinRepl *strings.Replacer
+}
+
+// NewNameFolder returns a new NameFolder with the user-defined
+// transliterations translit, which take precedence over the built-in ones
+func NewNameFolder(translit map[string]string) *NameFolder {
+	f := &NameFolder{}
+
+	if len(translit) > 0 {
+		f.userRepl = buildReplacer(translit, true)
+	}
+
+	f.builtinRepl = buildReplacer(builtinTranslit, false)
+
+	return f
+}
+
+// Fold a string
+func (f *NameFolder) Fold(s string) string {
+	s = strings.ToLower(s)
+
+	if f.userRepl != nil {
+		s = f.userRepl.Replace(s)
+	}
+
+	s = f.builtinRepl.Replace(s)
+
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err == nil {
+		s = folded
+	}
+
+	// characters with diacritics may only now be transliterated
+	return f.builtinRepl.Replace(s)
+}
+
+// BuiltinStopWords returns the built-in stop words for a language
+func BuiltinStopWords(lang string) []string {
+	return builtinStopWords[strings.ToLower(lang)]
+}
+
+// Build a replacer from a map, longer keys are matched first
+func buildReplacer(m map[string]string, lower bool) *strings.Replacer {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if len(k) > 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		if lower {
+			oldnew = append(oldnew, strings.ToLower(k), strings.ToLower(m[k]))
+		} else {
+			oldnew = append(oldnew, k, m[k])
+		}
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"testing"
+)
+
+func TestNameFolder(t *testing.T) {
+	f := NewNameFolder(map[string]string{"ü": "ue"})
+
+	tests := [][2]string{
+		{"Straße", "strasse"},
+		{"Zürich", "zuerich"},
+		{"Genève", "geneve"},
+		{"Москва", "moskva"},
+		{"Αθήνα", "athina"},
+		{"Łódź", "lodz"},
+	}
+
+	for _, test := range tests {
+		if f.Fold(test[0]) != test[1] {
+			t.Error("Expected '" + test[1] + "' for '" + test[0] + "', got '" + f.Fold(test[0]) + "'")
+		}
+	}
+
+	f = NewNameFolder(nil)
+
+	if f.Fold("Zürich") != f.Fold("ZURICH") {
+		t.Error("Expected 'Zürich' and 'ZURICH' to be equal after folding")
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// NetworkExporter writes the network of a feed as GeoJSON FeatureCollections
+// to the directory OutPath, for inspection in GIS tools:
+//
+//	stops.geojson         all stops with coordinates
+//	parent_links.geojson  lines from each stop to its parent station
+//	shapes.geojson        all shapes used by trips, with the routes and the
+//	                      number of trips using them
+//	patterns.geojson      the distinct stop sequences of each route, with
+//	                      the number of trips serving them
+//
+// If GpkgFile is set, the same data is written as layers stops,
+// parent_links, shapes and patterns to a GeoPackage file. Either output may
+// be left empty. The feed is not modified.
+type NetworkExporter struct {
+	OutPath  string
+	GpkgFile string
+}
+
+// Run this NetworkExporter on some feed
+func (ne NetworkExporter) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Exporting network... ")
+
+	stops, links := ne.stopFeatures(feed)
+	shapes := ne.shapeFeatures(feed)
+	patterns := ne.patternFeatures(feed)
+
+	failed := make([]string, 0)
+
+	if len(ne.OutPath) > 0 {
+		failed = append(failed, ne.writeGeoJSON(stops, links, shapes, patterns)...)
+	}
+
+	if len(ne.GpkgFile) > 0 {
+		layers := []gpkgLayer{
+			{"stops", "POINT", stops},
+			{"parent_links", "LINESTRING", links},
+			{"shapes", "LINESTRING", shapes},
+			{"patterns", "LINESTRING", patterns},
+		}
+		if err := writeGpkg(ne.GpkgFile, layers); err != nil {
+			failed = append(failed, fmt.Sprintf("Could not write GeoPackage %s: %s\n", ne.GpkgFile, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stdout, "done.\n")
+		for _, msg := range failed {
+			fmt.Fprint(os.Stderr, msg)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d stops, %d shapes, %d patterns)\n",
+		len(stops.Features),
+		len(shapes.Features),
+		len(patterns.Features))
+}
+
+// Write the features as GeoJSON files to OutPath, return an error message
+// for each file that could not be written
+func (ne NetworkExporter) writeGeoJSON(stops, links, shapes, patterns *geojson.FeatureCollection) []string {
+	if err := os.MkdirAll(ne.OutPath, os.ModePerm); err != nil {
+		return []string{fmt.Sprintf("Could not create GeoJSON output directory %s: %s\n", ne.OutPath, err.Error())}
+	}
+
+	files := map[string]*geojson.FeatureCollection{
+		"stops.geojson":        stops,
+		"parent_links.geojson": links,
+		"shapes.geojson":       shapes,
+		"patterns.geojson":     patterns,
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := make([]string, 0)
+	for _, name := range names {
+		if err := ne.write(filepath.Join(ne.OutPath, name), files[name]); err != nil {
+			failed = append(failed, fmt.Sprintf("Could not write %s: %s\n", name, err.Error()))
+		}
+	}
+
+	return failed
+}
+
+// Return the stops and the links to their parent stations as features
+func (ne NetworkExporter) stopFeatures(feed *gtfsparser.Feed) (*geojson.FeatureCollection, *geojson.FeatureCollection) {
+	stops := geojson.NewFeatureCollection()
+	links := geojson.NewFeatureCollection()
+
+	ids := make([]string, 0, len(feed.Stops))
+	for id := range feed.Stops {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		s := feed.Stops[id]
+		if !ne.hasCoord(s) {
+			continue
+		}
+
+		feat := geojson.NewPointFeature([]float64{float64(s.Lon), float64(s.Lat)})
+		feat.SetProperty("stop_id", s.Id)
+		feat.SetProperty("stop_name", s.Name)
+		feat.SetProperty("stop_code", s.Code)
+		feat.SetProperty("location_type", s.Location_type)
+		feat.SetProperty("platform_code", s.Platform_code)
+		feat.SetProperty("zone_id", s.Zone_id)
+		if s.Parent_station != nil {
+			feat.SetProperty("parent_station", s.Parent_station.Id)
+		}
+		if s.Level != nil {
+			feat.SetProperty("level_id", s.Level.Id)
+		}
+		stops.AddFeature(feat)
+
+		if s.Parent_station != nil && ne.hasCoord(s.Parent_station) {
+			link := geojson.NewLineStringFeature([][]float64{
+				{float64(s.Lon), float64(s.Lat)},
+				{float64(s.Parent_station.Lon), float64(s.Parent_station.Lat)},
+			})
+			link.SetProperty("stop_id", s.Id)
+			link.SetProperty("parent_station", s.Parent_station.Id)
+			link.SetProperty("location_type", s.Location_type)
+			links.AddFeature(link)
+		}
+	}
+
+	return stops, links
+}
+
+// Return the shapes used by trips as features
+func (ne NetworkExporter) shapeFeatures(feed *gtfsparser.Feed) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+
+	routes, trips, shapes := shapeUsage(feed)
+
+	for _, s := range shapes {
+		if len(s.Points) < 2 {
+			continue
+		}
+
+		coords := make([][]float64, len(s.Points))
+		for i, p := range s.Points {
+			coords[i] = []float64{float64(p.Lon), float64(p.Lat)}
+		}
+
+		rs := sortedRoutes(routes[s])
+		ids := make([]string, len(rs))
+		names := make([]string, len(rs))
+		types := make([]int16, len(rs))
+		for i, r := range rs {
+			ids[i] = r.Id
+			names[i] = r.Short_name
+			types[i] = r.Type
+		}
+
+		feat := geojson.NewLineStringFeature(coords)
+		feat.SetProperty("shape_id", s.Id)
+		feat.SetProperty("route_ids", ids)
+		feat.SetProperty("route_short_names", names)
+		feat.SetProperty("route_types", types)
+		feat.SetProperty("trips", trips[s])
+		fc.AddFeature(feat)
+	}
+
+	return fc
+}
+
+// Return the distinct stop sequences of each route as features
+func (ne NetworkExporter) patternFeatures(feed *gtfsparser.Feed) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+
+	type pattern struct {
+		route *gtfs.Route
+		trips []*gtfs.Trip
+	}
+
+	patterns := make(map[string]*pattern)
+	keys := make([]string, 0)
+
+	for _, t := range feed.Trips {
+		if len(t.StopTimes) < 2 {
+			continue
+		}
+		key := t.Route.Id + "\x01" + stopSeqKey(t)
+		p, ok := patterns[key]
+		if !ok {
+			p = &pattern{route: t.Route}
+			patterns[key] = p
+			keys = append(keys, key)
+		}
+		p.trips = append(p.trips, t)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		p := patterns[key]
+
+		sort.Slice(p.trips, func(i, j int) bool {
+			return p.trips[i].Id < p.trips[j].Id
+		})
+
+		t := p.trips[0]
+		coords := make([][]float64, 0, len(t.StopTimes))
+		ids := make([]string, 0, len(t.StopTimes))
+		for _, st := range t.StopTimes {
+			lat, lon := getStopLatLon(st.Stop())
+			coords = append(coords, []float64{float64(lon), float64(lat)})
+			ids = append(ids, st.Stop().Id)
+		}
+
+		feat := geojson.NewLineStringFeature(coords)
+		feat.SetProperty("route_id", p.route.Id)
+		feat.SetProperty("route_short_name", p.route.Short_name)
+		feat.SetProperty("route_type", p.route.Type)
+		feat.SetProperty("stop_ids", ids)
+		feat.SetProperty("num_stops", len(ids))
+		feat.SetProperty("trips", len(p.trips))
+		feat.SetProperty("example_trip_id", t.Id)
+		fc.AddFeature(feat)
+	}
+
+	return fc
+}
+
+// Check if a stop has coordinates
+func (ne NetworkExporter) hasCoord(s *gtfs.Stop) bool {
+	return !math.IsNaN(float64(s.Lat)) && !math.IsNaN(float64(s.Lon))
+}
+
+// Write a FeatureCollection to a file
+func (ne NetworkExporter) write(path string, fc *geojson.FeatureCollection) error {
+	json, err := fc.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, json, 0644)
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func readTestFeatures(t *testing.T, path string) *geojson.FeatureCollection {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fc
+}
+
+func TestNetworkExporter(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	dir := t.TempDir()
+	NetworkExporter{OutPath: dir}.Run(feed)
+
+	// stops
+	stops := readTestFeatures(t, filepath.Join(dir, "stops.geojson"))
+
+	if len(stops.Features) != len(feed.Stops) {
+		t.Error(len(stops.Features), len(feed.Stops))
+	}
+
+	found := false
+	for _, f := range stops.Features {
+		if f.Properties["stop_id"] != "STAGECOACH" {
+			continue
+		}
+		found = true
+		if !f.Geometry.IsPoint() || !FloatEquals(float32(f.Geometry.Point[0]), -116.751677, 0.000001) || !FloatEquals(float32(f.Geometry.Point[1]), 36.915682, 0.000001) {
+			t.Error(f.Geometry)
+		}
+	}
+	if !found {
+		t.Error("expected feature for STAGECOACH")
+	}
+
+	// parent links
+	links := readTestFeatures(t, filepath.Join(dir, "parent_links.geojson"))
+
+	numChilds := 0
+	for _, s := range feed.Stops {
+		if s.Parent_station != nil {
+			numChilds++
+		}
+	}
+
+	if len(links.Features) != numChilds {
+		t.Error(len(links.Features), numChilds)
+	}
+
+	found = false
+	for _, f := range links.Features {
+		if f.Properties["stop_id"] != "hasduplicateasparent" {
+			continue
+		}
+		found = true
+		if f.Properties["parent_station"] != "duplicateBB" {
+			t.Error(f.Properties)
+		}
+		if !f.Geometry.IsLineString() || len(f.Geometry.LineString) != 2 || !FloatEquals(float32(f.Geometry.LineString[1][0]), -73.975224, 0.000001) {
+			t.Error(f.Geometry)
+		}
+	}
+	if !found {
+		t.Error("expected parent link for hasduplicateasparent")
+	}
+
+	// patterns
+	patterns := readTestFeatures(t, filepath.Join(dir, "patterns.geojson"))
+
+	city := 0
+	for _, f := range patterns.Features {
+		switch f.Properties["route_id"] {
+		case "CITY":
+			// CITY1 and CITY2 serve the same stops in opposite directions
+			city++
+			if f.Properties["trips"] != 1.0 || f.Properties["num_stops"] != 5.0 || len(f.Geometry.LineString) != 5 {
+				t.Error(f.Properties)
+			}
+		case "AAMV":
+			ids := f.Properties["stop_ids"].([]interface{})
+			if ids[0] == "AMV" && (f.Properties["trips"] != 2.0 || f.Properties["example_trip_id"] != "AAMV2") {
+				t.Error(f.Properties)
+			}
+			if ids[0] == "BEATTY_AIRPORT" && f.Properties["trips"] != 1.0 {
+				t.Error(f.Properties)
+			}
+		}
+	}
+
+	if city != 2 {
+		t.Error(city)
+	}
+}
+
+func TestNetworkExporterGpkg(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "network.gpkg")
+	NetworkExporter{GpkgFile: path}.Run(feed)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		t.Fatal("expected SQLite header")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:]))
+	if pageSize != sqlitePageSize || len(data) != pageSize*int(binary.BigEndian.Uint32(data[28:])) {
+		t.Error(pageSize, len(data))
+	}
+
+	if binary.BigEndian.Uint32(data[68:]) != gpkgAppId || binary.BigEndian.Uint32(data[60:]) != gpkgVersion {
+		t.Error("expected GeoPackage application ID and version")
+	}
+
+	for _, l := range []string{"stops", "parent_links", "shapes", "patterns"} {
+		if !bytes.Contains(data, []byte("CREATE TABLE \""+l+"\"")) {
+			t.Errorf("expected layer %s", l)
+		}
+	}
+
+	stop, _ := NetworkExporter{}.stopFeatures(feed)
+	for _, f := range stop.Features {
+		if f.Properties["stop_id"] == "STAGECOACH" && !bytes.Contains(data, gpkgGeom(f.Geometry)) {
+			t.Error("expected geometry of STAGECOACH")
+		}
+	}
+}
@@ -25,6 +25,8 @@ const (
 	Stops
 	Transfers
 	Trips
+	Attributions
+	Translations
 )
 
 func MakeOrphanRemover(args []string) (OrphanRemover, error) {
@@ -41,6 +43,8 @@ func MakeOrphanRemover(args []string) (OrphanRemover, error) {
 			or.enabledFilters[Stops] = true
 			or.enabledFilters[Transfers] = true
 			or.enabledFilters[Trips] = true
+			or.enabledFilters[Attributions] = true
+			or.enabledFilters[Translations] = true
 		case "agency":
 			or.enabledFilters[Agency] = true
 		case "routes":
@@ -55,6 +59,10 @@ func MakeOrphanRemover(args []string) (OrphanRemover, error) {
 			or.enabledFilters[Transfers] = true
 		case "trips":
 			or.enabledFilters[Trips] = true
+		case "attributions":
+			or.enabledFilters[Attributions] = true
+		case "translations":
+			or.enabledFilters[Translations] = true
 		default:
 			return OrphanRemover{}, errors.New("Unsupported file '" + arg + "'")
 		}
@@ -79,6 +87,8 @@ func (or OrphanRemover) Run(feed *gtfsparser.Feed) {
 	serviceB := len(feed.Services)
 	routesB := len(feed.Routes)
 	agenciesB := len(feed.Agencies)
+	attributionsB := or.countAttributions(feed)
+	translationsB := or.countTranslations(feed)
 
 	if or.enabledFilters[Trips] {
 		or.removeTripOrphans(feed)
@@ -111,10 +121,18 @@ func (or OrphanRemover) Run(feed *gtfsparser.Feed) {
 		or.removeAgencyOrphans(feed)
 	}
 
+	if or.enabledFilters[Attributions] {
+		or.removeAttributionOrphans(feed)
+	}
+
+	if or.enabledFilters[Translations] {
+		or.removeTranslationOrphans(feed)
+	}
+
 	// delete transfers
 	feed.CleanTransfers()
 
-	fmt.Fprintf(os.Stdout, "done. (-%d trips [-%.2f%%], -%d stops [-%.2f%%], -%d shapes [-%.2f%%], -%d services [-%.2f%%], -%d routes [-%.2f%%], -%d agencies [-%.2f%%], -%d transfers [-%.2f%%])\n",
+	fmt.Fprintf(os.Stdout, "done. (-%d trips [-%.2f%%], -%d stops [-%.2f%%], -%d shapes [-%.2f%%], -%d services [-%.2f%%], -%d routes [-%.2f%%], -%d agencies [-%.2f%%], -%d transfers [-%.2f%%], -%d attributions [-%.2f%%], -%d translations [-%.2f%%])\n",
 		(tripsB - len(feed.Trips)),
 		100.0*float64(tripsB-len(feed.Trips))/(float64(tripsB)+0.001),
 		(stopsB - len(feed.Stops)),
@@ -128,7 +146,11 @@ func (or OrphanRemover) Run(feed *gtfsparser.Feed) {
 		(agenciesB - len(feed.Agencies)),
 		100.0*float64(agenciesB-len(feed.Agencies))/(float64(agenciesB)+0.001),
 		(transfersB - len(feed.Transfers)),
-		100.0*float64(transfersB-len(feed.Transfers))/(float64(transfersB)+0.001))
+		100.0*float64(transfersB-len(feed.Transfers))/(float64(transfersB)+0.001),
+		(attributionsB - or.countAttributions(feed)),
+		100.0*float64(attributionsB-or.countAttributions(feed))/(float64(attributionsB)+0.001),
+		(translationsB - or.countTranslations(feed)),
+		100.0*float64(translationsB-or.countTranslations(feed))/(float64(translationsB)+0.001))
 }
 
 // Remove transfer orphans
@@ -150,6 +172,9 @@ func (or OrphanRemover) removeTransferOrphans(feed *gtfsparser.Feed) {
 		inFromRoute := true
 		inToRoute := true
 
+		inFromTrip := true
+		inToTrip := true
+
 		if tk.From_stop != nil {
 			_, inFrom = referenced[tk.From_stop]
 		}
@@ -166,7 +191,15 @@ func (or OrphanRemover) removeTransferOrphans(feed *gtfsparser.Feed) {
 			_, inToRoute = referenced_routes[tk.To_route]
 		}
 
-		if inFrom && inTo && inFromRoute && inToRoute {
+		if tk.From_trip != nil {
+			inFromTrip = feed.Trips[tk.From_trip.Id] == tk.From_trip
+		}
+
+		if tk.To_trip != nil {
+			inToTrip = feed.Trips[tk.To_trip.Id] == tk.To_trip
+		}
+
+		if inFrom && inTo && inFromRoute && inToRoute && inFromTrip && inToTrip {
 			referenced_trans[tk] = empty{}
 		}
 	}
@@ -326,3 +359,149 @@ func (or OrphanRemover) removeAgencyOrphans(feed *gtfsparser.Feed) {
 		}
 	}
 }
+
+// Remove duplicate attributions of agencies, routes and trips, and
+// attributions equal to a feed-wide attribution
+func (or OrphanRemover) removeAttributionOrphans(feed *gtfsparser.Feed) {
+	feed.Attributions = mergeAttributions(make([]*gtfs.Attribution, 0, len(feed.Attributions)), feed.Attributions)
+
+	global := make(map[string]bool, len(feed.Attributions))
+	referenced := make(map[*gtfs.Attribution]empty, 0)
+	for _, attr := range feed.Attributions {
+		global[attributionKey(attr)] = true
+		referenced[attr] = empty{}
+	}
+
+	filter := func(attrs []*gtfs.Attribution) []*gtfs.Attribution {
+		ret := make([]*gtfs.Attribution, 0, len(attrs))
+		for _, attr := range mergeAttributions(make([]*gtfs.Attribution, 0, len(attrs)), attrs) {
+			if global[attributionKey(attr)] {
+				continue
+			}
+			ret = append(ret, attr)
+			referenced[attr] = empty{}
+		}
+		return ret
+	}
+
+	for _, a := range feed.Agencies {
+		a.Attributions = filter(a.Attributions)
+	}
+
+	for _, r := range feed.Routes {
+		r.Attributions = filter(r.Attributions)
+	}
+
+	for _, t := range feed.Trips {
+		if t.Attributions != nil {
+			*t.Attributions = filter(*t.Attributions)
+		}
+	}
+
+	// delete additional fields of removed attributions
+	for k := range feed.AttributionsAddFlds {
+		for attr := range feed.AttributionsAddFlds[k] {
+			if _, in := referenced[attr]; !in {
+				delete(feed.AttributionsAddFlds[k], attr)
+			}
+		}
+	}
+}
+
+// Return the number of attributions in the feed
+func (or OrphanRemover) countAttributions(feed *gtfsparser.Feed) int {
+	n := len(feed.Attributions)
+
+	for _, a := range feed.Agencies {
+		n += len(a.Attributions)
+	}
+
+	for _, r := range feed.Routes {
+		n += len(r.Attributions)
+	}
+
+	for _, t := range feed.Trips {
+		if t.Attributions != nil {
+			n += len(*t.Attributions)
+		}
+	}
+
+	return n
+}
+
+// Remove duplicate translations of stops, agencies, levels, pathways and
+// trips, and the additional fields of translations whose record was deleted
+func (or OrphanRemover) removeTranslationOrphans(feed *gtfsparser.Feed) {
+	referenced := make(map[*gtfs.Translation]empty, 0)
+
+	filter := func(trs []*gtfs.Translation) []*gtfs.Translation {
+		if len(trs) == 0 {
+			return trs
+		}
+		ret := mergeTranslations(make([]*gtfs.Translation, 0, len(trs)), trs)
+		for _, tr := range ret {
+			referenced[tr] = empty{}
+		}
+		return ret
+	}
+
+	for _, s := range feed.Stops {
+		s.Translations = filter(s.Translations)
+	}
+
+	for _, a := range feed.Agencies {
+		a.Translations = filter(a.Translations)
+	}
+
+	for _, l := range feed.Levels {
+		l.Translations = filter(l.Translations)
+	}
+
+	for _, p := range feed.Pathways {
+		p.Translations = filter(p.Translations)
+	}
+
+	for _, t := range feed.Trips {
+		if t.Translations != nil {
+			*t.Translations = filter(*t.Translations)
+		}
+	}
+
+	// delete additional fields of removed translations
+	for k := range feed.TranslationsAddFlds {
+		for tr := range feed.TranslationsAddFlds[k] {
+			if _, in := referenced[tr]; !in {
+				delete(feed.TranslationsAddFlds[k], tr)
+			}
+		}
+	}
+}
+
+// Return the number of translations in the feed
+func (or OrphanRemover) countTranslations(feed *gtfsparser.Feed) int {
+	n := 0
+
+	for _, s := range feed.Stops {
+		n += len(s.Translations)
+	}
+
+	for _, a := range feed.Agencies {
+		n += len(a.Translations)
+	}
+
+	for _, l := range feed.Levels {
+		n += len(l.Translations)
+	}
+
+	for _, p := range feed.Pathways {
+		n += len(p.Translations)
+	}
+
+	for _, t := range feed.Trips {
+		if t.Translations != nil {
+			n += len(*t.Translations)
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestTranslationOrphanRemoval(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	kept := newTestTranslation(t, "stop_name", "de", "Bahnhof")
+	dup := newTestTranslation(t, "stop_name", "de", "Hauptbahnhof")
+	deleted := newTestTranslation(t, "stop_name", "de", "Flughafen")
+
+	feed.Stops["STAGECOACH"].Translations = []*gtfs.Translation{kept, dup}
+	feed.Stops["BEATTY_AIRPORT"].Translations = []*gtfs.Translation{deleted}
+
+	feed.TranslationsAddFlds = map[string]map[*gtfs.Translation]string{"note": {kept: "a", dup: "b", deleted: "c"}}
+
+	feed.DeleteStop("BEATTY_AIRPORT")
+
+	or, err := MakeOrphanRemover([]string{"translations"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	or.Run(feed)
+
+	trs := feed.Stops["STAGECOACH"].Translations
+	if len(trs) != 1 || trs[0] != kept {
+		t.Error("Duplicate translation should have been removed")
+	}
+
+	if len(feed.TranslationsAddFlds["note"]) != 1 || feed.TranslationsAddFlds["note"][kept] != "a" {
+		t.Error("Additional fields of removed translations should have been removed")
+	}
+}
+
+func TestAttributionOrphanRemoval(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	agency := feed.Agencies["DTA"]
+	route := feed.Routes["AAM8"]
+
+	if len(agency.Attributions) != 2 || len(route.Attributions) != 1 {
+		t.Error("Unexpected attributions in test feed")
+		return
+	}
+
+	// a duplicate of an agency attribution with a different ID
+	dup := *agency.Attributions[0]
+	dup.Id = "dup"
+	agency.Attributions = append(agency.Attributions, &dup)
+
+	// a feed-wide attribution equal to the route attribution
+	global := *route.Attributions[0]
+	global.Id = "global"
+	feed.Attributions = append(feed.Attributions, &global, &global)
+
+	or, err := MakeOrphanRemover([]string{"attributions"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	or.Run(feed)
+
+	if len(feed.Attributions) != 1 {
+		t.Errorf("Expected 1 feed-wide attribution, got %d", len(feed.Attributions))
+	}
+
+	if len(agency.Attributions) != 1 {
+		t.Errorf("Expected 1 agency attribution, got %d", len(agency.Attributions))
+	}
+
+	if len(route.Attributions) != 0 {
+		t.Error("Route attribution equal to a feed-wide attribution should have been removed")
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Callbacks used while scanning an OSM file. If onNode is nil, node
+// positions are not decoded at all.
+type osmHandler struct {
+	onNode func(id int64, lat float64, lon float64)
+	onWay  func(id int64, refs []int64, tags map[string]string)
+}
+
+// Scan the OSM file at path, which may either be an OSM XML or an OSM PBF
+// file. Only nodes and ways are read, relations are ignored.
+func scanOsm(path string, h osmHandler) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 1<<16)
+
+	head, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	if head[0] == '<' || head[0] == ' ' || head[0] == '\n' || head[0] == '\r' || head[0] == '\t' || head[0] == 0xEF {
+		return scanOsmXml(r, h)
+	}
+
+	return scanOsmPbf(r, h)
+}
+
+// Scan an OSM XML file
+func scanOsmXml(r io.Reader, h osmHandler) error {
+	dec := xml.NewDecoder(r)
+
+	var wayId int64
+	var refs []int64
+	var tags map[string]string
+	inWay := false
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "node":
+				if h.onNode == nil {
+					continue
+				}
+				var id int64
+				var lat, lon float64
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "id":
+						id, err = strconv.ParseInt(a.Value, 10, 64)
+					case "lat":
+						lat, err = strconv.ParseFloat(a.Value, 64)
+					case "lon":
+						lon, err = strconv.ParseFloat(a.Value, 64)
+					}
+					if err != nil {
+						return fmt.Errorf("invalid node attribute '%s': %s", a.Name.Local, err.Error())
+					}
+				}
+				h.onNode(id, lat, lon)
+			case "way":
+				inWay = true
+				refs = make([]int64, 0)
+				tags = make(map[string]string)
+				for _, a := range t.Attr {
+					if a.Name.Local == "id" {
+						if wayId, err = strconv.ParseInt(a.Value, 10, 64); err != nil {
+							return fmt.Errorf("invalid way id '%s'", a.Value)
+						}
+					}
+				}
+			case "nd":
+				if !inWay {
+					continue
+				}
+				for _, a := range t.Attr {
+					if a.Name.Local == "ref" {
+						ref, err := strconv.ParseInt(a.Value, 10, 64)
+						if err != nil {
+							return fmt.Errorf("invalid node reference '%s' in way %d", a.Value, wayId)
+						}
+						refs = append(refs, ref)
+					}
+				}
+			case "tag":
+				if !inWay {
+					continue
+				}
+				var k, v string
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "k":
+						k = a.Value
+					case "v":
+						v = a.Value
+					}
+				}
+				tags[k] = v
+			}
+		case xml.EndElement:
+			if t.Name.Local == "way" && inWay {
+				inWay = false
+				if h.onWay != nil {
+					h.onWay(wayId, refs, tags)
+				}
+			}
+		}
+	}
+}
+
+// Scan an OSM PBF file
+func scanOsmPbf(r io.Reader, h osmHandler) error {
+	var lenBuf [4]byte
+
+	for {
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		headerBuf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(r, headerBuf); err != nil {
+			return err
+		}
+
+		blobType := ""
+		dataSize := 0
+
+		hdr := pbfMsg{buf: headerBuf}
+		for hdr.next() {
+			switch hdr.field {
+			case 1:
+				blobType = string(hdr.bytes())
+			case 3:
+				dataSize = int(hdr.varint())
+			default:
+				hdr.skip()
+			}
+		}
+		if hdr.err != nil {
+			return hdr.err
+		}
+
+		blobBuf := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, blobBuf); err != nil {
+			return err
+		}
+
+		if blobType != "OSMData" {
+			continue
+		}
+
+		data, err := decodePbfBlob(blobBuf)
+		if err != nil {
+			return err
+		}
+
+		if err := decodePbfBlock(data, h); err != nil {
+			return err
+		}
+	}
+}
+
+// Return the uncompressed content of a PBF blob
+func decodePbfBlob(buf []byte) ([]byte, error) {
+	blob := pbfMsg{buf: buf}
+	rawSize := 0
+	var raw, zdata []byte
+
+	for blob.next() {
+		switch blob.field {
+		case 1:
+			raw = blob.bytes()
+		case 2:
+			rawSize = int(blob.varint())
+		case 3:
+			zdata = blob.bytes()
+		case 4, 6, 7:
+			return nil, errors.New("unsupported PBF blob compression, only zlib is supported")
+		default:
+			blob.skip()
+		}
+	}
+	if blob.err != nil {
+		return nil, blob.err
+	}
+
+	if raw != nil {
+		return raw, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(zdata))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	ret := bytes.NewBuffer(make([]byte, 0, rawSize))
+	if _, err := io.Copy(ret, zr); err != nil {
+		return nil, err
+	}
+
+	return ret.Bytes(), nil
+}
+
+// Decode a PBF PrimitiveBlock
+func decodePbfBlock(buf []byte, h osmHandler) error {
+	strs := make([]string, 0)
+	groups := make([][]byte, 0)
+	var granularity int64 = 100
+	var latOffset, lonOffset int64
+
+	block := pbfMsg{buf: buf}
+	for block.next() {
+		switch block.field {
+		case 1:
+			st := pbfMsg{buf: block.bytes()}
+			for st.next() {
+				if st.field == 1 {
+					strs = append(strs, string(st.bytes()))
+				} else {
+					st.skip()
+				}
+			}
+			if st.err != nil {
+				return st.err
+			}
+		case 2:
+			groups = append(groups, block.bytes())
+		case 17:
+			granularity = int64(block.varint())
+		case 19:
+			latOffset = int64(block.varint())
+		case 20:
+			lonOffset = int64(block.varint())
+		default:
+			block.skip()
+		}
+	}
+	if block.err != nil {
+		return block.err
+	}
+
+	coord := func(offset int64, v int64) float64 {
+		return 1e-9 * float64(offset+granularity*v)
+	}
+
+	str := func(i uint64) string {
+		if i < uint64(len(strs)) {
+			return strs[i]
+		}
+		return ""
+	}
+
+	for _, g := range groups {
+		group := pbfMsg{buf: g}
+		for group.next() {
+			switch group.field {
+			case 1:
+				if h.onNode == nil {
+					group.skip()
+					continue
+				}
+				node := pbfMsg{buf: group.bytes()}
+				var id, lat, lon int64
+				for node.next() {
+					switch node.field {
+					case 1:
+						id = unzigzag(node.varint())
+					case 8:
+						lat = unzigzag(node.varint())
+					case 9:
+						lon = unzigzag(node.varint())
+					default:
+						node.skip()
+					}
+				}
+				if node.err != nil {
+					return node.err
+				}
+				h.onNode(id, coord(latOffset, lat), coord(lonOffset, lon))
+			case 2:
+				if h.onNode == nil {
+					group.skip()
+					continue
+				}
+				dense := pbfMsg{buf: group.bytes()}
+				var ids, lats, lons []uint64
+				for dense.next() {
+					switch dense.field {
+					case 1:
+						ids = dense.varints(ids)
+					case 8:
+						lats = dense.varints(lats)
+					case 9:
+						lons = dense.varints(lons)
+					default:
+						dense.skip()
+					}
+				}
+				if dense.err != nil {
+					return dense.err
+				}
+				if len(ids) != len(lats) || len(ids) != len(lons) {
+					return errors.New("invalid dense nodes in PBF block")
+				}
+				var id, lat, lon int64
+				for i := range ids {
+					id += unzigzag(ids[i])
+					lat += unzigzag(lats[i])
+					lon += unzigzag(lons[i])
+					h.onNode(id, coord(latOffset, lat), coord(lonOffset, lon))
+				}
+			case 3:
+				if h.onWay == nil {
+					group.skip()
+					continue
+				}
+				way := pbfMsg{buf: group.bytes()}
+				var id int64
+				var keys, vals, deltas []uint64
+				for way.next() {
+					switch way.field {
+					case 1:
+						id = int64(way.varint())
+					case 2:
+						keys = way.varints(keys)
+					case 3:
+						vals = way.varints(vals)
+					case 8:
+						deltas = way.varints(deltas)
+					default:
+						way.skip()
+					}
+				}
+				if way.err != nil {
+					return way.err
+				}
+				tags := make(map[string]string, len(keys))
+				for i := range keys {
+					if i < len(vals) {
+						tags[str(keys[i])] = str(vals[i])
+					}
+				}
+				refs := make([]int64, len(deltas))
+				var ref int64
+				for i, d := range deltas {
+					ref += unzigzag(d)
+					refs[i] = ref
+				}
+				h.onWay(id, refs, tags)
+			default:
+				group.skip()
+			}
+		}
+		if group.err != nil {
+			return group.err
+		}
+	}
+
+	return nil
+}
+
+// Minimal protocol buffer message reader
+type pbfMsg struct {
+	buf   []byte
+	pos   int
+	field int
+	wire  int
+	err   error
+}
+
+// Advance to the next field, return false at the end of the message
+func (m *pbfMsg) next() bool {
+	if m.err != nil || m.pos >= len(m.buf) {
+		return false
+	}
+	key := m.varint()
+	m.field = int(key >> 3)
+	m.wire = int(key & 7)
+	return m.err == nil
+}
+
+// Read a varint
+func (m *pbfMsg) varint() uint64 {
+	v, n := binary.Uvarint(m.buf[m.pos:])
+	if n <= 0 {
+		m.err = errors.New("invalid varint in PBF message")
+		m.pos = len(m.buf)
+		return 0
+	}
+	m.pos += n
+	return v
+}
+
+// Read a length-delimited field
+func (m *pbfMsg) bytes() []byte {
+	l := int(m.varint())
+	if m.err != nil {
+		return nil
+	}
+	if l < 0 || m.pos+l > len(m.buf) {
+		m.err = errors.New("invalid field length in PBF message")
+		m.pos = len(m.buf)
+		return nil
+	}
+	ret := m.buf[m.pos : m.pos+l]
+	m.pos += l
+	return ret
+}
+
+// Append the values of a (packed or unpacked) repeated varint field to vals
+func (m *pbfMsg) varints(vals []uint64) []uint64 {
+	if m.wire == 0 {
+		return append(vals, m.varint())
+	}
+
+	packed := pbfMsg{buf: m.bytes()}
+	for packed.pos < len(packed.buf) && packed.err == nil {
+		vals = append(vals, packed.varint())
+	}
+	if packed.err != nil {
+		m.err = packed.err
+	}
+	return vals
+}
+
+// Skip the current field
+func (m *pbfMsg) skip() {
+	switch m.wire {
+	case 0:
+		m.varint()
+	case 1:
+		m.pos += 8
+	case 2:
+		m.bytes()
+	case 5:
+		m.pos += 4
+	default:
+		m.err = fmt.Errorf("unsupported wire type %d in PBF message", m.wire)
+	}
+	if m.pos > len(m.buf) {
+		m.err = errors.New("truncated PBF message")
+		m.pos = len(m.buf)
+	}
+}
+
+// Decode a zigzag-encoded signed integer
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
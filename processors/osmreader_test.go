@@ -0,0 +1,234 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testReaderOsm = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="10" lat="48.0" lon="7.8"/>
+  <node id="5" lat="47.9995" lon="7.8012"/>
+  <node id="12" lat="48.001" lon="7.7999"/>
+  <way id="100">
+    <nd ref="10"/>
+    <nd ref="5"/>
+    <nd ref="12"/>
+    <tag k="highway" v="residential"/>
+    <tag k="name" v="Main Street"/>
+  </way>
+</osm>
+`
+
+type testOsmWay struct {
+	refs []int64
+	tags map[string]string
+}
+
+// Append a varint to buf
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// Append a varint field to a protocol buffer message
+func pbVarint(buf []byte, field int, v uint64) []byte {
+	buf = appendUvarint(buf, uint64(field<<3))
+	return appendUvarint(buf, v)
+}
+
+// Append a length-delimited field to a protocol buffer message
+func pbBytes(buf []byte, field int, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(field<<3|2))
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// Append a packed repeated varint field to a protocol buffer message
+func pbPacked(buf []byte, field int, vals []uint64) []byte {
+	packed := make([]byte, 0)
+	for _, v := range vals {
+		packed = appendUvarint(packed, v)
+	}
+	return pbBytes(buf, field, packed)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// Append a PBF fileblock of the given type with the given blob content
+func pbFileBlock(buf []byte, blobType string, data []byte, compress bool) []byte {
+	blob := make([]byte, 0)
+	if compress {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(data)
+		w.Close()
+		blob = pbVarint(blob, 2, uint64(len(data)))
+		blob = pbBytes(blob, 3, z.Bytes())
+	} else {
+		blob = pbBytes(blob, 1, data)
+	}
+
+	hdr := pbBytes(nil, 1, []byte(blobType))
+	hdr = pbVarint(hdr, 3, uint64(len(blob)))
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(hdr)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, hdr...)
+	return append(buf, blob...)
+}
+
+// Build a PBF file equivalent to testReaderOsm, and return it together
+// with the offsets at which its fileblocks end
+func buildTestPbf() ([]byte, []int) {
+	ends := make([]int, 0)
+
+	pbf := pbFileBlock(nil, "OSMHeader", pbBytes(nil, 4, []byte("OsmSchema-V0.6")), false)
+	ends = append(ends, len(pbf))
+
+	// dense nodes with non-default granularity and offsets
+	var granularity, latOffset, lonOffset int64 = 1000, 2000, -3000
+	ids := []int64{10, 5, 12}
+	lats := []float64{48.0, 47.9995, 48.001}
+	lons := []float64{7.8, 7.8012, 7.7999}
+
+	var dIds, dLats, dLons []uint64
+	var lastId, lastLat, lastLon int64
+	for i := range ids {
+		lat := (int64(math.Round(lats[i]*1e9)) - latOffset) / granularity
+		lon := (int64(math.Round(lons[i]*1e9)) - lonOffset) / granularity
+		dIds = append(dIds, zigzag(ids[i]-lastId))
+		dLats = append(dLats, zigzag(lat-lastLat))
+		dLons = append(dLons, zigzag(lon-lastLon))
+		lastId, lastLat, lastLon = ids[i], lat, lon
+	}
+
+	dense := pbPacked(nil, 1, dIds)
+	dense = pbPacked(dense, 8, dLats)
+	dense = pbPacked(dense, 9, dLons)
+
+	block := pbBytes(nil, 1, pbBytes(nil, 1, nil))
+	block = pbBytes(block, 2, pbBytes(nil, 2, dense))
+	block = pbVarint(block, 17, uint64(granularity))
+	block = pbVarint(block, 19, uint64(latOffset))
+	block = pbVarint(block, 20, uint64(lonOffset))
+
+	pbf = pbFileBlock(pbf, "OSMData", block, false)
+	ends = append(ends, len(pbf))
+
+	// way with packed, delta-coded refs and tags
+	strs := make([]byte, 0)
+	for _, s := range []string{"", "highway", "residential", "name", "Main Street"} {
+		strs = pbBytes(strs, 1, []byte(s))
+	}
+
+	way := pbVarint(nil, 1, 100)
+	way = pbPacked(way, 2, []uint64{1, 3})
+	way = pbPacked(way, 3, []uint64{2, 4})
+	way = pbPacked(way, 8, []uint64{zigzag(10), zigzag(-5), zigzag(7)})
+
+	block = pbBytes(nil, 1, strs)
+	block = pbBytes(block, 2, pbBytes(nil, 3, way))
+
+	pbf = pbFileBlock(pbf, "OSMData", block, true)
+	ends = append(ends, len(pbf))
+
+	return pbf, ends
+}
+
+// Scan the OSM file at path and return all nodes and ways
+func scanTestOsm(path string) (map[int64][2]float64, map[int64]testOsmWay, error) {
+	nodes := make(map[int64][2]float64)
+	ways := make(map[int64]testOsmWay)
+
+	err := scanOsm(path, osmHandler{
+		onNode: func(id int64, lat float64, lon float64) {
+			nodes[id] = [2]float64{lat, lon}
+		},
+		onWay: func(id int64, refs []int64, tags map[string]string) {
+			ways[id] = testOsmWay{refs, tags}
+		},
+	})
+
+	return nodes, ways, err
+}
+
+func TestScanOsmPbf(t *testing.T) {
+	dir := t.TempDir()
+
+	xmlPath := filepath.Join(dir, "test.osm")
+	if err := os.WriteFile(xmlPath, []byte(testReaderOsm), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pbf, _ := buildTestPbf()
+	pbfPath := filepath.Join(dir, "test.osm.pbf")
+	if err := os.WriteFile(pbfPath, pbf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	xmlNodes, xmlWays, err := scanTestOsm(xmlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pbfNodes, pbfWays, err := scanTestOsm(pbfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(xmlNodes) != 3 || len(pbfNodes) != len(xmlNodes) {
+		t.Error(xmlNodes, pbfNodes)
+	}
+
+	for id, xc := range xmlNodes {
+		pc, ok := pbfNodes[id]
+		if !ok || math.Abs(pc[0]-xc[0]) > 1e-9 || math.Abs(pc[1]-xc[1]) > 1e-9 {
+			t.Errorf("node %d: expected %v, got %v", id, xc, pc)
+		}
+	}
+
+	if len(xmlWays) != 1 || !reflect.DeepEqual(xmlWays, pbfWays) {
+		t.Error(xmlWays, pbfWays)
+	}
+}
+
+func TestScanOsmPbfTruncated(t *testing.T) {
+	dir := t.TempDir()
+	pbf, ends := buildTestPbf()
+
+	isEnd := make(map[int]bool)
+	for _, e := range ends {
+		isEnd[e] = true
+	}
+
+	for l := 1; l < len(pbf); l++ {
+		path := filepath.Join(dir, "test.osm.pbf")
+		if err := os.WriteFile(path, pbf[:l], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err := scanTestOsm(path)
+
+		// cutting between two fileblocks yields a shorter, but valid file
+		if !isEnd[l] && err == nil {
+			t.Errorf("expected error for PBF truncated to %d of %d bytes", l, len(pbf))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// PathwayGenerator generates basic walkway pathways for stations without
+// any pathways. If a station has no entrance, an entrance is generated at
+// the station position and connected to each platform. If the station
+// already has entrances, a generic node is generated at the station
+// position and connected to each entrance and each platform. Platforms
+// with boarding areas are connected via their boarding areas.
+type PathwayGenerator struct {
+	WalkingSpeed   float64 // in m/s
+	GenerateLevels bool
+}
+
+// Run this PathwayGenerator on some feed
+func (pg PathwayGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating pathways... ")
+
+	bef := len(feed.Pathways)
+	befStops := len(feed.Stops)
+
+	childs := make(map[*gtfs.Stop][]*gtfs.Stop)
+	hasPathway := make(map[*gtfs.Stop]bool)
+
+	for _, s := range feed.Stops {
+		if s.Parent_station != nil {
+			childs[s.Parent_station] = append(childs[s.Parent_station], s)
+		}
+	}
+
+	for _, p := range feed.Pathways {
+		hasPathway[pg.station(p.From_stop)] = true
+		hasPathway[pg.station(p.To_stop)] = true
+	}
+
+	var level *gtfs.Level
+
+	stations := make([]*gtfs.Stop, 0)
+	for _, s := range feed.Stops {
+		if s.Location_type == 1 && !hasPathway[s] {
+			stations = append(stations, s)
+		}
+	}
+
+	for _, station := range stations {
+		entrances := make([]*gtfs.Stop, 0)
+		nodes := make([]*gtfs.Stop, 0)
+		platforms := make([]*gtfs.Stop, 0)
+
+		for _, c := range childs[station] {
+			switch c.Location_type {
+			case 0:
+				if len(childs[c]) > 0 {
+					// boarding areas have to be used instead of the platform
+					platforms = append(platforms, childs[c]...)
+				} else {
+					platforms = append(platforms, c)
+				}
+			case 2:
+				entrances = append(entrances, c)
+			case 3:
+				// unconnected generic nodes would be invalid
+				nodes = append(nodes, c)
+			}
+		}
+
+		if len(platforms) == 0 {
+			continue
+		}
+
+		if pg.GenerateLevels && level == nil {
+			level = pg.getLevel(feed)
+		}
+
+		var hub *gtfs.Stop
+
+		if len(entrances) == 0 {
+			hub = pg.createNode(feed, station, 2, "ent::", level)
+		} else {
+			hub = pg.createNode(feed, station, 3, "node::", level)
+			platforms = append(platforms, entrances...)
+		}
+
+		platforms = append(platforms, nodes...)
+
+		for _, p := range platforms {
+			if level != nil && p.Level == nil {
+				p.Level = level
+			}
+			pg.createPathway(feed, p, hub)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d pathways, +%d stops)\n", len(feed.Pathways)-bef, len(feed.Stops)-befStops)
+}
+
+// Return the station a stop belongs to
+func (pg PathwayGenerator) station(s *gtfs.Stop) *gtfs.Stop {
+	for s.Parent_station != nil && s.Location_type != 1 {
+		s = s.Parent_station
+	}
+	return s
+}
+
+// Return the ground level, create it if it does not exist
+func (pg PathwayGenerator) getLevel(feed *gtfsparser.Feed) *gtfs.Level {
+	for _, l := range feed.Levels {
+		if l.Index == 0 {
+			return l
+		}
+	}
+
+	lvl := &gtfs.Level{Id: "lvl::0", Index: 0, Name: ""}
+
+	for try := 1; ; try++ {
+		if _, ok := feed.Levels[lvl.Id]; !ok {
+			break
+		}
+		lvl.Id = "lvl" + strconv.Itoa(try) + "::0"
+	}
+
+	feed.Levels[lvl.Id] = lvl
+
+	return lvl
+}
+
+// Create a new stop of some location type at the station's position
+func (pg PathwayGenerator) createNode(feed *gtfsparser.Feed, station *gtfs.Stop, locType int8, prefix string, level *gtfs.Level) *gtfs.Stop {
+	node := *station
+
+	for try := 0; ; try++ {
+		if try == 0 {
+			node.Id = prefix + station.Id
+		} else {
+			node.Id = prefix[:len(prefix)-2] + strconv.Itoa(try) + "::" + station.Id
+		}
+		if _, ok := feed.Stops[node.Id]; !ok {
+			break
+		}
+	}
+
+	node.Code = ""
+	node.Desc = ""
+	node.Platform_code = ""
+	node.Zone_id = ""
+	node.Location_type = locType
+	node.Parent_station = station
+	node.Level = level
+	node.Translations = nil
+
+	feed.Stops[node.Id] = &node
+
+	return &node
+}
+
+// Create a bidirectional walkway between two stops
+func (pg PathwayGenerator) createPathway(feed *gtfsparser.Feed, from *gtfs.Stop, to *gtfs.Stop) {
+	latA, lonA := getStopLatLon(from)
+	latB, lonB := getStopLatLon(to)
+	d := haversine(float64(latA), float64(lonA), float64(latB), float64(lonB))
+
+	pw := &gtfs.Pathway{
+		From_stop:        from,
+		To_stop:          to,
+		Mode:             1,
+		Is_bidirectional: true,
+		Length:           float32(d),
+		Traversal_time:   imax(1, int(math.Ceil(d/pg.WalkingSpeed))),
+		Min_width:        float32(math.NaN()),
+	}
+
+	for try := 0; ; try++ {
+		if try == 0 {
+			pw.Id = "pw::" + from.Id
+		} else {
+			pw.Id = "pw" + strconv.Itoa(try) + "::" + from.Id
+		}
+		if _, ok := feed.Pathways[pw.Id]; !ok {
+			break
+		}
+	}
+
+	feed.Pathways[pw.Id] = pw
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestPathwayGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	// a station with an entrance and a platform with a boarding area
+	st := &gtfs.Stop{Id: "ST", Name: "Station", Lat: 36.9, Lon: -116.7, Location_type: 1}
+	ent := &gtfs.Stop{Id: "ST_E", Lat: 36.901, Lon: -116.7, Location_type: 2, Parent_station: st}
+	plat := &gtfs.Stop{Id: "ST_P", Lat: 36.9, Lon: -116.701, Location_type: 0, Parent_station: st}
+	ba := &gtfs.Stop{Id: "ST_B", Lat: 36.9, Lon: -116.702, Location_type: 4, Parent_station: plat}
+	for _, s := range []*gtfs.Stop{st, ent, plat, ba} {
+		feed.Stops[s.Id] = s
+	}
+
+	bef := len(feed.Pathways)
+
+	proc := PathwayGenerator{WalkingSpeed: 1, GenerateLevels: true}
+	proc.Run(feed)
+
+	if len(feed.Pathways) != bef+3 {
+		t.Errorf("expected %d pathways, got %d", bef+3, len(feed.Pathways))
+	}
+
+	// F12 already has pathways
+	if _, ok := feed.Stops["ent::F12"]; ok {
+		t.Error("no entrance should be generated for F12")
+	}
+
+	// duplicateA has no platforms
+	if _, ok := feed.Stops["ent::duplicateA"]; ok {
+		t.Error("no entrance should be generated for duplicateA")
+	}
+
+	// duplicateBB has a single platform and no entrances
+	hub, ok := feed.Stops["ent::duplicateBB"]
+	if !ok {
+		t.Error("expected a generated entrance for duplicateBB")
+		return
+	}
+	if hub.Location_type != 2 || hub.Parent_station != feed.Stops["duplicateBB"] {
+		t.Error("generated entrance for duplicateBB is not an entrance of the station")
+	}
+	if hub.Level == nil || hub.Level.Id != "L0" {
+		t.Error("generated entrance should be on the existing ground level")
+	}
+
+	pw, ok := feed.Pathways["pw::hasduplicateasparent"]
+	if !ok || pw.To_stop != hub || pw.Mode != 1 || !pw.Is_bidirectional {
+		t.Error("expected a bidirectional walkway from the platform to the entrance")
+	}
+
+	// ST already has an entrance, so a generic node is used as the hub
+	node, ok := feed.Stops["node::ST"]
+	if !ok || node.Location_type != 3 {
+		t.Error("expected a generated generic node for ST")
+		return
+	}
+
+	if pw, ok := feed.Pathways["pw::ST_E"]; !ok || pw.To_stop != node {
+		t.Error("expected a walkway from the entrance to the generic node")
+	}
+
+	if pw, ok := feed.Pathways["pw::ST_B"]; !ok || pw.To_stop != node {
+		t.Error("expected a walkway from the boarding area to the generic node")
+	} else if pw.Traversal_time != int(pw.Length)+1 {
+		t.Errorf("expected traversal time %d, got %d", int(pw.Length)+1, pw.Traversal_time)
+	}
+
+	if _, ok := feed.Pathways["pw::ST_P"]; ok {
+		t.Error("platforms with boarding areas should be connected via their boarding areas")
+	}
+}
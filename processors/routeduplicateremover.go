@@ -28,6 +28,7 @@ func (rdr RouteDuplicateRemover) Run(feed *gtfsparser.Feed) {
 	bef := len(feed.Routes)
 
 	trips := make(map[*gtfs.Route][]*gtfs.Trip, len(feed.Routes))
+	transfers := getRouteTransfers(feed)
 	stops := make(map[*gtfs.Route]map[*gtfs.Stop]bool, len(feed.Routes))
 
 	for _, t := range feed.Trips {
@@ -66,7 +67,7 @@ func (rdr RouteDuplicateRemover) Run(feed *gtfsparser.Feed) {
 		eqRoutes := rdr.getEquivalentRoutes(r, feed, chunks[hash], stops)
 
 		if len(eqRoutes) > 0 {
-			rdr.combineRoutes(feed, append(eqRoutes, r), trips)
+			rdr.combineRoutes(feed, append(eqRoutes, r), trips, transfers)
 
 			for _, rt := range eqRoutes {
 				proced[rt] = true
@@ -214,7 +215,7 @@ func (rdr RouteDuplicateRemover) fareRulesEqual(attr *gtfs.FareAttribute, a *gtf
 }
 
 // Combine a slice of equal routes into a single route
-func (rdr RouteDuplicateRemover) combineRoutes(feed *gtfsparser.Feed, routes []*gtfs.Route, trips map[*gtfs.Route][]*gtfs.Trip) {
+func (rdr RouteDuplicateRemover) combineRoutes(feed *gtfsparser.Feed, routes []*gtfs.Route, trips map[*gtfs.Route][]*gtfs.Trip, transfers map[*gtfs.Route][]gtfs.TransferKey) {
 	// heuristic: use the route with the shortest ID as 'reference'
 	ref := routes[0]
 
@@ -235,9 +236,9 @@ func (rdr RouteDuplicateRemover) combineRoutes(feed *gtfsparser.Feed, routes []*
 			}
 		}
 
-		for _, attr := range r.Attributions {
-			ref.Attributions = append(ref.Attributions, attr)
-		}
+		ref.Attributions = mergeAttributions(ref.Attributions, r.Attributions)
+
+		redirectRouteTransfers(feed, transfers, r, ref)
 
 		// delete every fare rule that contains this route
 		for _, fa := range feed.FareAttributes {
@@ -0,0 +1,680 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// ShapeGenerator generates shapes for trips without a shape by map-matching
+// their stop sequence onto a network read from a local OSM file (XML or
+// PBF). The network is chosen by the route type: tram tracks for trams,
+// railway tracks for rail and subway, roads for buses and trolleybuses,
+// ferry routes for ferries, and so on.
+//
+// Each stop is snapped to up to MaxCands nearby network positions within
+// MaxSnapDist, and the sequence of positions minimizing the network
+// distance plus SnapPenalty times the snapping distances is chosen. Trips
+// for which no stop position is found or for which two consecutive stops
+// are not connected within MaxDetour times their straight-line distance are
+// left without a shape. Measurements (in meters) are written to both the
+// generated shape and the trip's stop times.
+type ShapeGenerator struct {
+	OsmFile     string
+	MaxSnapDist float64 // in meters
+	MaxDetour   float64
+	MaxCands    int
+	SnapPenalty float64
+}
+
+// Network classes of OSM ways
+const (
+	osmRoad = iota
+	osmRail
+	osmTram
+	osmSubway
+	osmFunicular
+	osmMonorail
+	osmFerry
+	osmAerial
+	osmNumClasses
+)
+
+// A directed edge of the OSM network
+type osmEdge struct {
+	to     int
+	length float64
+}
+
+// An undirected segment of the OSM network, used for snapping
+type osmSeg struct {
+	a, b int
+	fwd  bool
+	bwd  bool
+}
+
+// A network position a stop was snapped to
+type osmCand struct {
+	seg  int
+	frac float64
+	lat  float64
+	lon  float64
+	dist float64
+}
+
+// The network of a single OSM class
+type osmGraph struct {
+	lat  []float64
+	lon  []float64
+	adj  [][]osmEdge
+	segs []osmSeg
+	grid map[[2]int][]int
+}
+
+// size of grid cells in degrees
+const osmGridSize = 0.005
+
+// Run this ShapeGenerator on some feed
+func (sg ShapeGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating shapes from OSM data... ")
+
+	trips := make([][]*gtfs.Trip, osmNumClasses)
+	needed := make([]bool, osmNumClasses)
+	num := 0
+
+	for _, t := range feed.Trips {
+		if t.Shape != nil || len(t.StopTimes) < 2 {
+			continue
+		}
+		class := sg.osmClass(t.Route.Type)
+		if class < 0 {
+			continue
+		}
+		trips[class] = append(trips[class], t)
+		needed[class] = true
+		num++
+	}
+
+	if num == 0 {
+		fmt.Fprintf(os.Stdout, "done. (no trips without shapes)\n")
+		return
+	}
+
+	graphs, err := sg.buildGraphs(needed)
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "done.\n")
+		fmt.Fprintf(os.Stderr, "Could not read OSM file %s: %s\n", sg.OsmFile, err.Error())
+		return
+	}
+
+	bef := len(feed.Shapes)
+	matched := 0
+
+	for class, classTrips := range trips {
+		if len(classTrips) == 0 {
+			continue
+		}
+
+		sort.Slice(classTrips, func(i, j int) bool {
+			return classTrips[i].Id < classTrips[j].Id
+		})
+
+		// trips with the same stop sequence share the same shape
+		cache := make(map[string]*gtfs.Shape)
+		dists := make(map[string][]float64)
+
+		for _, t := range classTrips {
+			key := stopSeqKey(t)
+
+			shp, ok := cache[key]
+			if !ok {
+				var d []float64
+				shp, d = sg.matchTrip(graphs[class], t)
+				if shp != nil {
+					for try := 0; ; try++ {
+						if try == 0 {
+							shp.Id = "osm::" + t.Id
+						} else {
+							shp.Id = "osm" + strconv.Itoa(try) + "::" + t.Id
+						}
+						if _, ok := feed.Shapes[shp.Id]; !ok {
+							break
+						}
+					}
+					feed.Shapes[shp.Id] = shp
+				}
+				cache[key] = shp
+				dists[key] = d
+			}
+
+			if shp == nil {
+				continue
+			}
+
+			t.Shape = shp
+			for i := range t.StopTimes {
+				t.StopTimes[i].SetShape_dist_traveled(float32(dists[key][i]))
+			}
+			matched++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d shapes, %d of %d trips without shapes matched [%.2f%%])\n",
+		len(feed.Shapes)-bef,
+		matched,
+		num,
+		100.0*float64(matched)/(float64(num)+0.001))
+}
+
+// Return the OSM network class for a route type, or -1 if unsupported
+func (sg ShapeGenerator) osmClass(t int16) int {
+	switch gtfs.GetTypeFromExtended(t) {
+	case 0, 5:
+		return osmTram
+	case 1:
+		return osmSubway
+	case 2:
+		return osmRail
+	case 3, 11:
+		return osmRoad
+	case 4:
+		return osmFerry
+	case 6:
+		return osmAerial
+	case 7:
+		return osmFunicular
+	case 12:
+		return osmMonorail
+	}
+	return -1
+}
+
+// Return the network classes an OSM way belongs to
+func (sg ShapeGenerator) wayClasses(tags map[string]string) []int {
+	ret := make([]int, 0)
+
+	switch tags["highway"] {
+	case "motorway", "trunk", "primary", "secondary", "tertiary", "unclassified", "residential", "living_street", "service", "road", "busway", "bus_guideway", "motorway_link", "trunk_link", "primary_link", "secondary_link", "tertiary_link":
+		ret = append(ret, osmRoad)
+	}
+
+	switch tags["railway"] {
+	case "rail", "narrow_gauge":
+		ret = append(ret, osmRail, osmSubway)
+	case "light_rail":
+		ret = append(ret, osmRail, osmSubway, osmTram)
+	case "subway":
+		ret = append(ret, osmSubway)
+	case "tram":
+		ret = append(ret, osmTram)
+	case "funicular":
+		ret = append(ret, osmFunicular)
+	case "monorail":
+		ret = append(ret, osmMonorail)
+	}
+
+	if tags["route"] == "ferry" {
+		ret = append(ret, osmFerry)
+	}
+
+	switch tags["aerialway"] {
+	case "cable_car", "gondola", "mixed_lift", "chair_lift":
+		ret = append(ret, osmAerial)
+	}
+
+	return ret
+}
+
+// Return whether a way can be traversed forward and backward by vehicles of
+// some network class
+func (sg ShapeGenerator) wayDirs(tags map[string]string, class int) (bool, bool) {
+	if class != osmRoad {
+		return true, true
+	}
+
+	if tags["oneway:bus"] == "no" || tags["oneway:psv"] == "no" || tags["busway"] == "opposite_lane" {
+		return true, true
+	}
+
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		return true, false
+	case "-1", "reverse":
+		return false, true
+	case "no", "false", "0":
+		return true, true
+	}
+
+	if tags["junction"] == "roundabout" || tags["highway"] == "motorway" {
+		return true, false
+	}
+
+	return true, true
+}
+
+// Read the OSM file and build the networks of the needed classes
+func (sg ShapeGenerator) buildGraphs(needed []bool) ([]*osmGraph, error) {
+	type way struct {
+		refs    []int64
+		classes []int
+		dirs    [][2]bool
+	}
+
+	ways := make([]way, 0)
+	nodes := make(map[int64]int)
+
+	// first pass: collect relevant ways and the nodes they reference
+	err := scanOsm(sg.OsmFile, osmHandler{onWay: func(id int64, refs []int64, tags map[string]string) {
+		if len(refs) < 2 {
+			return
+		}
+
+		w := way{refs: refs}
+		for _, c := range sg.wayClasses(tags) {
+			if needed[c] {
+				fwd, bwd := sg.wayDirs(tags, c)
+				w.classes = append(w.classes, c)
+				w.dirs = append(w.dirs, [2]bool{fwd, bwd})
+			}
+		}
+
+		if len(w.classes) == 0 {
+			return
+		}
+
+		for _, r := range refs {
+			nodes[r] = -1
+		}
+		ways = append(ways, w)
+	}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// second pass: read node positions
+	lats := make([]float64, 0, len(nodes))
+	lons := make([]float64, 0, len(nodes))
+
+	err = scanOsm(sg.OsmFile, osmHandler{onNode: func(id int64, lat float64, lon float64) {
+		if i, ok := nodes[id]; ok && i == -1 {
+			nodes[id] = len(lats)
+			lats = append(lats, lat)
+			lons = append(lons, lon)
+		}
+	}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	graphs := make([]*osmGraph, osmNumClasses)
+	gids := make([]map[int]int, osmNumClasses)
+
+	for _, w := range ways {
+		for ci, c := range w.classes {
+			if graphs[c] == nil {
+				graphs[c] = &osmGraph{grid: make(map[[2]int][]int)}
+				gids[c] = make(map[int]int)
+			}
+			g := graphs[c]
+
+			prev := -1
+			for _, ref := range w.refs {
+				gi, ok := nodes[ref]
+				if !ok || gi < 0 {
+					// node missing in extract
+					prev = -1
+					continue
+				}
+
+				cur, ok := gids[c][gi]
+				if !ok {
+					cur = len(g.lat)
+					gids[c][gi] = cur
+					g.lat = append(g.lat, lats[gi])
+					g.lon = append(g.lon, lons[gi])
+					g.adj = append(g.adj, nil)
+				}
+
+				if prev >= 0 && prev != cur {
+					g.addSeg(prev, cur, w.dirs[ci][0], w.dirs[ci][1])
+				}
+				prev = cur
+			}
+		}
+	}
+
+	return graphs, nil
+}
+
+// Add a segment between nodes a and b to the graph
+func (g *osmGraph) addSeg(a int, b int, fwd bool, bwd bool) {
+	l := haversine(g.lat[a], g.lon[a], g.lat[b], g.lon[b])
+
+	if fwd {
+		g.adj[a] = append(g.adj[a], osmEdge{b, l})
+	}
+	if bwd {
+		g.adj[b] = append(g.adj[b], osmEdge{a, l})
+	}
+
+	id := len(g.segs)
+	g.segs = append(g.segs, osmSeg{a, b, fwd, bwd})
+
+	minX, maxX := int(math.Floor(math.Min(g.lon[a], g.lon[b])/osmGridSize)), int(math.Floor(math.Max(g.lon[a], g.lon[b])/osmGridSize))
+	minY, maxY := int(math.Floor(math.Min(g.lat[a], g.lat[b])/osmGridSize)), int(math.Floor(math.Max(g.lat[a], g.lat[b])/osmGridSize))
+
+	for x := minX; x <= maxX; x++ {
+		for y := minY; y <= maxY; y++ {
+			g.grid[[2]int{x, y}] = append(g.grid[[2]int{x, y}], id)
+		}
+	}
+}
+
+// Return the network positions within maxDist of a point, nearest first
+func (g *osmGraph) candidates(lat float64, lon float64, maxDist float64, maxCands int) []osmCand {
+	dLat := maxDist / 111111.0
+	dLon := dLat / math.Max(0.01, math.Cos(lat*DEG_TO_RAD))
+
+	seen := make(map[int]bool)
+	ret := make([]osmCand, 0)
+
+	for x := int(math.Floor((lon - dLon) / osmGridSize)); x <= int(math.Floor((lon+dLon)/osmGridSize)); x++ {
+		for y := int(math.Floor((lat - dLat) / osmGridSize)); y <= int(math.Floor((lat+dLat)/osmGridSize)); y++ {
+			for _, sid := range g.grid[[2]int{x, y}] {
+				if seen[sid] {
+					continue
+				}
+				seen[sid] = true
+
+				seg := g.segs[sid]
+
+				// project in a local equirectangular projection
+				scale := math.Cos(lat * DEG_TO_RAD)
+				ax, ay := (g.lon[seg.a]-lon)*scale, g.lat[seg.a]-lat
+				bx, by := (g.lon[seg.b]-lon)*scale, g.lat[seg.b]-lat
+
+				frac := 0.0
+				if l := (bx-ax)*(bx-ax) + (by-ay)*(by-ay); l > 0 {
+					frac = math.Max(0, math.Min(1, -(ax*(bx-ax)+ay*(by-ay))/l))
+				}
+
+				pLat := g.lat[seg.a] + frac*(g.lat[seg.b]-g.lat[seg.a])
+				pLon := g.lon[seg.a] + frac*(g.lon[seg.b]-g.lon[seg.a])
+				d := haversine(lat, lon, pLat, pLon)
+
+				if d <= maxDist {
+					ret = append(ret, osmCand{sid, frac, pLat, pLon, d})
+				}
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].dist != ret[j].dist {
+			return ret[i].dist < ret[j].dist
+		}
+		return ret[i].seg < ret[j].seg
+	})
+
+	if len(ret) > maxCands {
+		ret = ret[:maxCands]
+	}
+
+	return ret
+}
+
+// Return the length of the segment seg
+func (g *osmGraph) segLen(seg osmSeg) float64 {
+	return haversine(g.lat[seg.a], g.lon[seg.a], g.lat[seg.b], g.lon[seg.b])
+}
+
+// A min-heap of nodes for Dijkstra searches
+type osmHeapItem struct {
+	node int
+	cost float64
+}
+
+type osmHeap []osmHeapItem
+
+func (h osmHeap) Len() int            { return len(h) }
+func (h osmHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h osmHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *osmHeap) Push(x interface{}) { *h = append(*h, x.(osmHeapItem)) }
+func (h *osmHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// The result of routing from one candidate to all candidates of the next stop
+type osmRoute struct {
+	cost []float64 // per target candidate, +Inf if unreachable
+	via  []int     // per target candidate, the node the target is reached over, or -1 if reached directly
+	pred map[int]int
+}
+
+// Route from candidate p to all candidates in targets, up to maxCost
+func (g *osmGraph) route(p osmCand, targets []osmCand, maxCost float64) osmRoute {
+	ret := osmRoute{cost: make([]float64, len(targets)), via: make([]int, len(targets)), pred: make(map[int]int)}
+
+	dist := make(map[int]float64)
+	settled := make(map[int]bool)
+	pq := make(osmHeap, 0)
+
+	seg := g.segs[p.seg]
+	l := g.segLen(seg)
+
+	if seg.fwd {
+		dist[seg.b] = (1 - p.frac) * l
+		ret.pred[seg.b] = -1
+		heap.Push(&pq, osmHeapItem{seg.b, dist[seg.b]})
+	}
+	if seg.bwd {
+		if d, ok := dist[seg.a]; !ok || p.frac*l < d {
+			dist[seg.a] = p.frac * l
+			ret.pred[seg.a] = -1
+			heap.Push(&pq, osmHeapItem{seg.a, dist[seg.a]})
+		}
+	}
+
+	for pq.Len() > 0 {
+		cur := heap.Pop(&pq).(osmHeapItem)
+		if cur.cost > maxCost {
+			break
+		}
+		if settled[cur.node] {
+			continue
+		}
+		settled[cur.node] = true
+
+		for _, e := range g.adj[cur.node] {
+			nd := cur.cost + e.length
+			if d, ok := dist[e.to]; !ok || nd < d {
+				dist[e.to] = nd
+				ret.pred[e.to] = cur.node
+				heap.Push(&pq, osmHeapItem{e.to, nd})
+			}
+		}
+	}
+
+	for i, q := range targets {
+		ret.cost[i] = math.Inf(1)
+		ret.via[i] = -1
+
+		qseg := g.segs[q.seg]
+		ql := g.segLen(qseg)
+
+		// both positions on the same segment
+		if q.seg == p.seg {
+			if qseg.fwd && q.frac >= p.frac {
+				ret.cost[i] = (q.frac - p.frac) * ql
+			} else if qseg.bwd && q.frac <= p.frac {
+				ret.cost[i] = (p.frac - q.frac) * ql
+			}
+		}
+
+		if d, ok := dist[qseg.a]; ok && settled[qseg.a] && qseg.fwd && d+q.frac*ql < ret.cost[i] {
+			ret.cost[i] = d + q.frac*ql
+			ret.via[i] = qseg.a
+		}
+		if d, ok := dist[qseg.b]; ok && settled[qseg.b] && qseg.bwd && d+(1-q.frac)*ql < ret.cost[i] {
+			ret.cost[i] = d + (1-q.frac)*ql
+			ret.via[i] = qseg.b
+		}
+	}
+
+	return ret
+}
+
+// Match the stop sequence of a trip onto the network. Return the generated
+// shape (without ID) and the measurement of each stop time, or nil if no
+// consistent match was found.
+func (sg ShapeGenerator) matchTrip(g *osmGraph, t *gtfs.Trip) (*gtfs.Shape, []float64) {
+	if g == nil {
+		return nil, nil
+	}
+
+	n := len(t.StopTimes)
+	cands := make([][]osmCand, n)
+
+	for i, st := range t.StopTimes {
+		lat, lon := getStopLatLon(st.Stop())
+		cands[i] = g.candidates(float64(lat), float64(lon), sg.MaxSnapDist, sg.MaxCands)
+		if len(cands[i]) == 0 {
+			return nil, nil
+		}
+	}
+
+	// viterbi over the candidates
+	costs := make([][]float64, n)
+	back := make([][]int, n)
+	routes := make([][]osmRoute, n)
+
+	costs[0] = make([]float64, len(cands[0]))
+	for j, c := range cands[0] {
+		costs[0][j] = sg.SnapPenalty * c.dist
+	}
+
+	for i := 1; i < n; i++ {
+		costs[i] = make([]float64, len(cands[i]))
+		back[i] = make([]int, len(cands[i]))
+		routes[i] = make([]osmRoute, len(cands[i-1]))
+
+		for j := range costs[i] {
+			costs[i][j] = math.Inf(1)
+			back[i][j] = -1
+		}
+
+		latA, lonA := getStopLatLon(t.StopTimes[i-1].Stop())
+		latB, lonB := getStopLatLon(t.StopTimes[i].Stop())
+		straight := haversine(float64(latA), float64(lonA), float64(latB), float64(lonB))
+		maxCost := sg.MaxDetour*straight + 2*sg.MaxSnapDist
+
+		for k, p := range cands[i-1] {
+			if math.IsInf(costs[i-1][k], 1) {
+				continue
+			}
+
+			routes[i][k] = g.route(p, cands[i], maxCost)
+
+			for j, q := range cands[i] {
+				c := costs[i-1][k] + routes[i][k].cost[j] + sg.SnapPenalty*q.dist
+				if c < costs[i][j] {
+					costs[i][j] = c
+					back[i][j] = k
+				}
+			}
+		}
+	}
+
+	best := -1
+	for j, c := range costs[n-1] {
+		if !math.IsInf(c, 1) && (best < 0 || c < costs[n-1][best]) {
+			best = j
+		}
+	}
+
+	if best < 0 {
+		return nil, nil
+	}
+
+	// backtrack the chosen candidates
+	chosen := make([]int, n)
+	chosen[n-1] = best
+	for i := n - 1; i > 0; i-- {
+		chosen[i-1] = back[i][chosen[i]]
+	}
+
+	shp := &gtfs.Shape{Points: make(gtfs.ShapePoints, 0)}
+	dists := make([]float64, n)
+	total := 0.0
+
+	addPoint := func(lat float64, lon float64) {
+		if len(shp.Points) > 0 {
+			last := shp.Points[len(shp.Points)-1]
+			d := haversine(float64(last.Lat), float64(last.Lon), lat, lon)
+			if d == 0 {
+				return
+			}
+			total += d
+		}
+		shp.Points = append(shp.Points, gtfs.ShapePoint{
+			Lat:           float32(lat),
+			Lon:           float32(lon),
+			Sequence:      uint32(len(shp.Points)),
+			Dist_traveled: float32(total),
+		})
+	}
+
+	first := cands[0][chosen[0]]
+	addPoint(first.lat, first.lon)
+
+	for i := 1; i < n; i++ {
+		r := routes[i][chosen[i-1]]
+		q := cands[i][chosen[i]]
+
+		nodes := make([]int, 0)
+		for cur := r.via[chosen[i]]; cur >= 0; cur = r.pred[cur] {
+			nodes = append(nodes, cur)
+		}
+
+		for j := len(nodes) - 1; j >= 0; j-- {
+			addPoint(g.lat[nodes[j]], g.lon[nodes[j]])
+		}
+
+		addPoint(q.lat, q.lon)
+		dists[i] = total
+	}
+
+	if len(shp.Points) < 2 {
+		return nil, nil
+	}
+
+	return shp, dists
+}
+
+// Return a key identifying the stop sequence of a trip
+func stopSeqKey(t *gtfs.Trip) string {
+	ids := make([]string, len(t.StopTimes))
+	for i, st := range t.StopTimes {
+		ids[i] = st.Stop().Id
+	}
+	return strings.Join(ids, "\x00")
+}
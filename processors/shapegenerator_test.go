@@ -0,0 +1,81 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+)
+
+const testOsm = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+ <node id="1" lat="36.9157" lon="-116.7517"/>
+ <node id="2" lat="36.8900" lon="-116.7650"/>
+ <node id="3" lat="36.8684" lon="-116.7846"/>
+ <node id="4" lat="36.9000" lon="-116.7000"/>
+ <way id="10">
+  <nd ref="1"/>
+  <nd ref="2"/>
+  <nd ref="3"/>
+  <tag k="highway" v="primary"/>
+ </way>
+ <way id="11">
+  <nd ref="1"/>
+  <nd ref="4"/>
+  <tag k="railway" v="rail"/>
+ </way>
+</osm>
+`
+
+func TestShapeGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "test.osm")
+	if err := os.WriteFile(path, []byte(testOsm), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sg := ShapeGenerator{OsmFile: path, MaxSnapDist: 100, MaxDetour: 3, MaxCands: 8, SnapPenalty: 2}
+	sg.Run(feed)
+
+	trip := feed.Trips["STBA"]
+	if trip.Shape == nil {
+		t.Error("expected generated shape for trip STBA")
+		return
+	}
+
+	if trip.Shape.Id != "osm::STBA" {
+		t.Error(trip.Shape.Id)
+	}
+
+	if len(trip.Shape.Points) != 3 {
+		t.Error(trip.Shape.Points)
+	}
+
+	if trip.StopTimes[0].Shape_dist_traveled() != 0 {
+		t.Error(trip.StopTimes[0].Shape_dist_traveled())
+	}
+
+	last := trip.Shape.Points[len(trip.Shape.Points)-1]
+	if d := trip.StopTimes[1].Shape_dist_traveled(); d < 6000 || d > 7000 || d != last.Dist_traveled {
+		t.Error(d, last.Dist_traveled)
+	}
+
+	// trips whose stops are not on the network are left without a shape
+	if feed.Trips["AB3"].Shape != nil {
+		t.Error("expected no shape for trip AB3")
+	}
+}
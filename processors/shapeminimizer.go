@@ -7,15 +7,36 @@
 package processors
 
 import (
+	"container/heap"
 	"fmt"
 	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
 	"os"
+	"sort"
 )
 
-// ShapeMinimizer minimizes shapes.
+// ShapeMinimizer minimizes shapes, either using the Douglas-Peucker
+// algorithm ("dp", the default) or the Visvalingam-Whyatt algorithm ("vw").
+// Epsilon is given in meters: for Douglas-Peucker, it is the max distance
+// between a removed point and the simplified shape, for Visvalingam-Whyatt,
+// points whose effective triangle area is below Epsilon² square meters are
+// removed.
+//
+// If KeepStopPoints is set, the points enclosing the shape_dist_traveled of
+// each stop time using the shape are kept, so measured stop positions on the
+// shape do not change. For stop times without a measurement, the shape point
+// closest to the stop is kept.
 type ShapeMinimizer struct {
-	Epsilon float64
+	Epsilon        float64
+	Algo           string
+	KeepStopPoints bool
+}
+
+// Stops and measurements of stop times referencing a single shape
+type shapeStops struct {
+	measures []float32
+	stops    []*gtfs.Stop
 }
 
 // Run this ShapeMinimizer on some feed
@@ -27,6 +48,11 @@ func (sm ShapeMinimizer) Run(feed *gtfsparser.Feed) {
 	chunkgain := make([]int, numchunks)
 	chunknum := make([]int, numchunks)
 
+	var stops map[*gtfs.Shape]*shapeStops
+	if sm.KeepStopPoints {
+		stops = sm.collectStops(feed)
+	}
+
 	curchunk := 0
 	for _, s := range feed.Shapes {
 		chunks[curchunk] = append(chunks[curchunk], s)
@@ -41,7 +67,7 @@ func (sm ShapeMinimizer) Run(feed *gtfsparser.Feed) {
 			for _, s := range chunk {
 				bef := len(s.Points)
 				chunknum[a] += len(s.Points)
-				s.Points = sm.minimizeShape(s.Points, sm.Epsilon)
+				s.Points = sm.minimize(s.Points, sm.fixedPoints(s, stops[s]))
 				for i := 0; i < len(s.Points); i++ {
 					s.Points[i].Sequence = uint32(i)
 				}
@@ -69,11 +95,129 @@ func (sm ShapeMinimizer) Run(feed *gtfsparser.Feed) {
 		100.0*float64(n)/(float64(orign)+0.001))
 }
 
+// Collect the measurements and unmeasured stops of the stop times using each shape
+func (sm ShapeMinimizer) collectStops(feed *gtfsparser.Feed) map[*gtfs.Shape]*shapeStops {
+	ret := make(map[*gtfs.Shape]*shapeStops)
+	seenStops := make(map[*gtfs.Shape]map[*gtfs.Stop]bool)
+
+	for _, t := range feed.Trips {
+		if t.Shape == nil {
+			continue
+		}
+
+		ss, ok := ret[t.Shape]
+		if !ok {
+			ss = &shapeStops{}
+			ret[t.Shape] = ss
+			seenStops[t.Shape] = make(map[*gtfs.Stop]bool)
+		}
+
+		for _, st := range t.StopTimes {
+			if st.HasDistanceTraveled() {
+				ss.measures = append(ss.measures, st.Shape_dist_traveled())
+			} else if !seenStops[t.Shape][st.Stop()] {
+				seenStops[t.Shape][st.Stop()] = true
+				ss.stops = append(ss.stops, st.Stop())
+			}
+		}
+	}
+
+	return ret
+}
+
+// Return the indices of the points of shape s which must be kept
+func (sm ShapeMinimizer) fixedPoints(s *gtfs.Shape, ss *shapeStops) []bool {
+	fixed := make([]bool, len(s.Points))
+	if len(s.Points) == 0 {
+		return fixed
+	}
+
+	fixed[0] = true
+	fixed[len(s.Points)-1] = true
+
+	if ss == nil {
+		return fixed
+	}
+
+	measured := true
+	for i := range s.Points {
+		if !s.Points[i].HasDistanceTraveled() {
+			measured = false
+			break
+		}
+	}
+
+	for _, m := range ss.measures {
+		if !measured {
+			break
+		}
+		i := sort.Search(len(s.Points), func(i int) bool {
+			return s.Points[i].Dist_traveled >= m
+		})
+		if i < len(s.Points) {
+			fixed[i] = true
+		}
+		if i > 0 && (i == len(s.Points) || s.Points[i].Dist_traveled != m) {
+			fixed[i-1] = true
+		}
+	}
+
+	for _, stop := range ss.stops {
+		lat, lon := getStopLatLon(stop)
+		best := -1
+		bestD := math.Inf(1)
+		for i := range s.Points {
+			d := haversineApprox(float64(lat), float64(lon), float64(s.Points[i].Lat), float64(s.Points[i].Lon))
+			if d < bestD {
+				best = i
+				bestD = d
+			}
+		}
+		if best >= 0 {
+			fixed[best] = true
+		}
+	}
+
+	return fixed
+}
+
+// Minimize the points of a shape, keeping all points marked as fixed
+func (sm ShapeMinimizer) minimize(points gtfs.ShapePoints, fixed []bool) gtfs.ShapePoints {
+	if len(points) < 3 {
+		return points
+	}
+
+	if sm.Algo == "vw" {
+		return sm.minimizeShapeVW(points, fixed)
+	}
+
+	// simplify the parts between fixed points independently
+	ret := make(gtfs.ShapePoints, 0, len(points))
+	last := 0
+	for i := 1; i < len(points); i++ {
+		if !fixed[i] {
+			continue
+		}
+		part := sm.minimizeShape(points[last:i+1], sm.Epsilon)
+		if len(ret) > 0 {
+			part = part[1:]
+		}
+		ret = append(ret, part...)
+		last = i
+	}
+
+	return ret
+}
+
 // Minimize a single shape using the Douglas-Peucker algorithm
 func (sm *ShapeMinimizer) minimizeShape(points gtfs.ShapePoints, e float64) gtfs.ShapePoints {
 	var maxD float64
 	var maxI int
 
+	if len(points) < 3 {
+		return append(gtfs.ShapePoints{}, points...)
+	}
+
 	for i := 1; i < len(points)-1; i++ {
 		// reproject to web mercator to be on euclidean plane
 		px, py := latLngToWebMerc(points[i].Lat, points[i].Lon)
@@ -81,7 +225,8 @@ func (sm *ShapeMinimizer) minimizeShape(points gtfs.ShapePoints, e float64) gtfs
 		lbx, lby := latLngToWebMerc(points[len(points)-1].Lat, points[len(points)-1].Lon)
 
 		// TODO: this is not entirely correct, we should check the measurement distance here also!
-		d := perpendicularDist(px, py, lax, lay, lbx, lby)
+		// web mercator distances are scaled by 1/cos(lat), correct this to get meters
+		d := perpendicularDist(px, py, lax, lay, lbx, lby) * math.Cos(float64(points[i].Lat)*DEG_TO_RAD)
 		if d > maxD {
 			maxI = i
 			maxD = d
@@ -97,3 +242,98 @@ func (sm *ShapeMinimizer) minimizeShape(points gtfs.ShapePoints, e float64) gtfs
 
 	return gtfs.ShapePoints{points[0], points[len(points)-1]}
 }
+
+// An entry of the Visvalingam-Whyatt queue
+type vwItem struct {
+	area    float64
+	i       int
+	version int
+}
+
+type vwHeap []vwItem
+
+func (h vwHeap) Len() int            { return len(h) }
+func (h vwHeap) Less(i, j int) bool  { return h[i].area < h[j].area }
+func (h vwHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *vwHeap) Push(x interface{}) { *h = append(*h, x.(vwItem)) }
+func (h *vwHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// Minimize a single shape using the Visvalingam-Whyatt algorithm
+func (sm *ShapeMinimizer) minimizeShapeVW(points gtfs.ShapePoints, fixed []bool) gtfs.ShapePoints {
+	n := len(points)
+	x := make([]float64, n)
+	y := make([]float64, n)
+	for i := range points {
+		x[i], y[i] = latLngToWebMerc(points[i].Lat, points[i].Lon)
+	}
+
+	prev := make([]int, n)
+	next := make([]int, n)
+	version := make([]int, n)
+	removed := make([]bool, n)
+
+	for i := range points {
+		prev[i] = i - 1
+		next[i] = i + 1
+	}
+
+	area := func(i int) float64 {
+		a := prev[i]
+		b := next[i]
+		merc := math.Abs((x[a]-x[i])*(y[b]-y[i])-(x[b]-x[i])*(y[a]-y[i])) / 2
+		// web mercator areas are scaled by 1/cos²(lat), correct this to get square meters
+		c := math.Cos(float64(points[i].Lat) * DEG_TO_RAD)
+		return merc * c * c
+	}
+
+	h := make(vwHeap, 0, n)
+	for i := 1; i < n-1; i++ {
+		if !fixed[i] {
+			h = append(h, vwItem{area(i), i, 0})
+		}
+	}
+	heap.Init(&h)
+
+	threshold := sm.Epsilon * sm.Epsilon
+
+	for h.Len() > 0 {
+		it := heap.Pop(&h).(vwItem)
+		if removed[it.i] || it.version != version[it.i] {
+			continue
+		}
+		if it.area >= threshold && !(it.area == 0 && threshold == 0) {
+			break
+		}
+
+		removed[it.i] = true
+		a := prev[it.i]
+		b := next[it.i]
+		next[a] = b
+		prev[b] = a
+
+		// the effective area of a neighbor is never smaller than that
+		// of an already removed point
+		for _, j := range []int{a, b} {
+			if j == 0 || j == n-1 || fixed[j] {
+				continue
+			}
+			version[j]++
+			heap.Push(&h, vwItem{math.Max(area(j), it.area), j, version[j]})
+		}
+	}
+
+	ret := make(gtfs.ShapePoints, 0, n)
+	for i := range points {
+		if !removed[i] {
+			ret = append(ret, points[i])
+		}
+	}
+
+	return ret
+}
@@ -67,3 +67,70 @@ func TestShapeMinimizer(t *testing.T) {
 		t.Error(feed.Shapes["B_shp"].Points[3])
 	}
 }
+
+func TestShapeMinimizerEpsilonMeters(t *testing.T) {
+	// at latitude 60, web mercator distances are twice the distance in meters
+	points := gtfs.ShapePoints{
+		{Lat: 60, Lon: 10},
+		{Lat: 60.00003, Lon: 10.001},
+		{Lat: 60, Lon: 10.002},
+	}
+
+	proc := ShapeMinimizer{Epsilon: 5}
+	if ret := proc.minimize(points, []bool{true, false, true}); len(ret) != 2 {
+		t.Error(ret)
+	}
+
+	proc = ShapeMinimizer{Epsilon: 2}
+	if ret := proc.minimize(points, []bool{true, false, true}); len(ret) != 3 {
+		t.Error(ret)
+	}
+}
+
+func TestShapeMinimizerVW(t *testing.T) {
+	points := gtfs.ShapePoints{
+		{Lat: 0, Lon: 0},
+		{Lat: 0, Lon: 0.001},
+		{Lat: 0.00001, Lon: 0.002},
+		{Lat: 0, Lon: 0.003},
+		{Lat: 0.001, Lon: 0.004},
+	}
+
+	proc := ShapeMinimizer{Epsilon: 15, Algo: "vw"}
+	ret := proc.minimize(points, []bool{true, false, false, false, true})
+
+	if len(ret) != 3 {
+		t.Error(ret)
+		return
+	}
+
+	if !shapePointEquals(ret[1], points[3]) {
+		t.Error(ret[1])
+	}
+}
+
+func TestShapeMinimizerKeepStopPoints(t *testing.T) {
+	points := gtfs.ShapePoints{
+		{Lat: 0, Lon: 0, Dist_traveled: 0},
+		{Lat: 0, Lon: 0.001, Dist_traveled: 111},
+		{Lat: 0, Lon: 0.002, Dist_traveled: 222},
+		{Lat: 0, Lon: 0.003, Dist_traveled: 333},
+	}
+
+	shp := &gtfs.Shape{Id: "shp", Points: points}
+
+	for _, algo := range []string{"dp", "vw"} {
+		proc := ShapeMinimizer{Epsilon: 5, Algo: algo, KeepStopPoints: true}
+		ret := proc.minimize(points, proc.fixedPoints(shp, &shapeStops{measures: []float32{150}}))
+
+		if len(ret) != 4 {
+			t.Error(algo, ret)
+		}
+
+		ret = proc.minimize(points, proc.fixedPoints(shp, &shapeStops{measures: []float32{222}}))
+
+		if len(ret) != 3 || !shapePointEquals(ret[1], points[2]) {
+			t.Error(algo, ret)
+		}
+	}
+}
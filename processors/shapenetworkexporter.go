@@ -0,0 +1,303 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// ShapeNetworkExporter decomposes the shapes used by trips into a network of
+// shared segments and writes it as GeoJSON to OutFile. Shape parts within
+// MaxEqDist (in meters) of each other, regardless of their direction, are
+// considered to be the same segment. Nodes are placed where shapes diverge
+// or where the set of routes using a segment changes, and each edge lists
+// the routes and the number of trips using it. The feed is not modified.
+type ShapeNetworkExporter struct {
+	OutFile   string
+	MaxEqDist float64
+}
+
+// A node of the shape network
+type shapeNetNode struct {
+	lat float64
+	lon float64
+	adj []int
+}
+
+// An edge between two consecutive nodes of the shape network
+type shapeNetEdge struct {
+	a, b    int
+	routes  map[*gtfs.Route]bool
+	trips   int
+	visited bool
+}
+
+// The network of shared shape segments
+type shapeNet struct {
+	nodes []shapeNetNode
+	edges map[[2]int]*shapeNetEdge
+	grid  map[[2]int][]int
+	cell  float64
+}
+
+// Run this ShapeNetworkExporter on some feed
+func (se ShapeNetworkExporter) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Exporting shape network... ")
+
+	routes, trips, shapes := shapeUsage(feed)
+
+	net := &shapeNet{
+		edges: make(map[[2]int]*shapeNetEdge),
+		grid:  make(map[[2]int][]int),
+		cell:  se.MaxEqDist / 111319.4,
+	}
+
+	for _, s := range shapes {
+		se.addShape(net, s, routes[s], trips[s])
+	}
+
+	fc, numEdges, numNodes := se.buildFeatures(net)
+
+	json, err := fc.MarshalJSON()
+	if err == nil {
+		err = os.WriteFile(se.OutFile, json, 0644)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "done.\n")
+		fmt.Fprintf(os.Stderr, "Could not write shape network to %s: %s\n", se.OutFile, err.Error())
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d shapes decomposed into %d edges between %d nodes)\n",
+		len(shapes),
+		numEdges,
+		numNodes)
+}
+
+// Add a shape to the network
+func (se ShapeNetworkExporter) addShape(net *shapeNet, s *gtfs.Shape, routes map[*gtfs.Route]bool, trips int) {
+	seen := make(map[[2]int]bool)
+	last := -1
+
+	add := func(lat float64, lon float64) {
+		n := net.getNode(lat, lon, se.MaxEqDist)
+		if n == last {
+			return
+		}
+
+		if last >= 0 {
+			key := [2]int{imin(last, n), imax(last, n)}
+			e, ok := net.edges[key]
+			if !ok {
+				e = &shapeNetEdge{a: key[0], b: key[1], routes: make(map[*gtfs.Route]bool)}
+				net.edges[key] = e
+				net.nodes[key[0]].adj = append(net.nodes[key[0]].adj, key[1])
+				net.nodes[key[1]].adj = append(net.nodes[key[1]].adj, key[0])
+			}
+			for r := range routes {
+				e.routes[r] = true
+			}
+			if !seen[key] {
+				seen[key] = true
+				e.trips += trips
+			}
+		}
+
+		last = n
+	}
+
+	// densify the shape, so parallel shapes are mapped to the same nodes
+	step := se.MaxEqDist / 2
+
+	for i := range s.Points {
+		lat := float64(s.Points[i].Lat)
+		lon := float64(s.Points[i].Lon)
+
+		if i > 0 {
+			prevLat := float64(s.Points[i-1].Lat)
+			prevLon := float64(s.Points[i-1].Lon)
+			n := int(haversineApprox(prevLat, prevLon, lat, lon) / step)
+			for j := 1; j < n; j++ {
+				f := float64(j) / float64(n)
+				add(prevLat+f*(lat-prevLat), prevLon+f*(lon-prevLon))
+			}
+		}
+
+		add(lat, lon)
+	}
+}
+
+// Return the node within maxDist of a position, or create a new one
+func (net *shapeNet) getNode(lat float64, lon float64, maxDist float64) int {
+	cy := int(math.Floor(lat / net.cell))
+	cx := int(math.Floor(lon / net.cell))
+
+	// grid cells are narrower in meters away from the equator
+	kx := int(math.Ceil(1 / math.Max(0.01, math.Cos(lat*DEG_TO_RAD))))
+
+	best := -1
+	bestD := maxDist
+
+	for y := cy - 1; y <= cy+1; y++ {
+		for x := cx - kx; x <= cx+kx; x++ {
+			for _, n := range net.grid[[2]int{x, y}] {
+				d := haversineApprox(lat, lon, net.nodes[n].lat, net.nodes[n].lon)
+				if d <= bestD {
+					best = n
+					bestD = d
+				}
+			}
+		}
+	}
+
+	if best >= 0 {
+		return best
+	}
+
+	net.nodes = append(net.nodes, shapeNetNode{lat: lat, lon: lon})
+	net.grid[[2]int{cx, cy}] = append(net.grid[[2]int{cx, cy}], len(net.nodes)-1)
+
+	return len(net.nodes) - 1
+}
+
+// Return the edge between two nodes
+func (net *shapeNet) edge(a int, b int) *shapeNetEdge {
+	return net.edges[[2]int{imin(a, b), imax(a, b)}]
+}
+
+// Return a key identifying the routes and trips using an edge
+func (net *shapeNet) lineKey(e *shapeNetEdge) string {
+	ids := make([]string, 0, len(e.routes))
+	for r := range e.routes {
+		ids = append(ids, r.Id)
+	}
+	sort.Strings(ids)
+	return fmt.Sprintf("%s\x00%d", strings.Join(ids, "\x00"), e.trips)
+}
+
+// Check if a node ends the edges contracted from the network
+func (net *shapeNet) isBreak(n int) bool {
+	adj := net.nodes[n].adj
+	if len(adj) != 2 {
+		return true
+	}
+	return net.lineKey(net.edge(n, adj[0])) != net.lineKey(net.edge(n, adj[1]))
+}
+
+// Contract chains of segments with the same routes and trips into single
+// edges, and return them together with the break nodes as GeoJSON features
+func (se ShapeNetworkExporter) buildFeatures(net *shapeNet) (*geojson.FeatureCollection, int, int) {
+	fc := geojson.NewFeatureCollection()
+	numEdges := 0
+	numNodes := 0
+
+	breaks := make([]bool, len(net.nodes))
+	for n := range net.nodes {
+		breaks[n] = net.isBreak(n)
+	}
+
+	walk := func(start int, next int) {
+		e := net.edge(start, next)
+		coords := [][]float64{{net.nodes[start].lon, net.nodes[start].lat}}
+		prev := start
+		cur := next
+
+		for {
+			net.edge(prev, cur).visited = true
+			coords = append(coords, []float64{net.nodes[cur].lon, net.nodes[cur].lat})
+
+			if breaks[cur] || cur == start {
+				break
+			}
+
+			// continue on the other edge of this degree 2 node
+			adj := net.nodes[cur].adj
+			nxt := adj[0]
+			if nxt == prev {
+				nxt = adj[1]
+			}
+
+			if net.edge(cur, nxt).visited {
+				break
+			}
+
+			prev = cur
+			cur = nxt
+		}
+
+		routeIds := make([]string, 0, len(e.routes))
+		names := make([]string, 0, len(e.routes))
+		rs := sortedRoutes(e.routes)
+		for _, r := range rs {
+			routeIds = append(routeIds, r.Id)
+			names = append(names, r.Short_name)
+		}
+
+		// drop the points added by densification
+		pts := make(gtfs.ShapePoints, len(coords))
+		for i, c := range coords {
+			pts[i] = gtfs.ShapePoint{Lat: float32(c[1]), Lon: float32(c[0])}
+		}
+		pts = (&ShapeMinimizer{}).minimizeShape(pts, 1.0)
+		coords = make([][]float64, len(pts))
+		for i, p := range pts {
+			coords[i] = []float64{float64(p.Lon), float64(p.Lat)}
+		}
+
+		feat := geojson.NewLineStringFeature(coords)
+		feat.SetProperty("from_node", start)
+		feat.SetProperty("to_node", cur)
+		feat.SetProperty("routes", routeIds)
+		feat.SetProperty("route_short_names", names)
+		feat.SetProperty("num_routes", len(rs))
+		feat.SetProperty("trips", e.trips)
+		fc.AddFeature(feat)
+		numEdges++
+	}
+
+	for n := range net.nodes {
+		if !breaks[n] {
+			continue
+		}
+		for _, m := range net.nodes[n].adj {
+			if !net.edge(n, m).visited {
+				walk(n, m)
+			}
+		}
+	}
+
+	// remaining edges form cycles without any break node
+	for n := range net.nodes {
+		for _, m := range net.nodes[n].adj {
+			if !net.edge(n, m).visited {
+				walk(n, m)
+			}
+		}
+	}
+
+	for n := range net.nodes {
+		if !breaks[n] || len(net.nodes[n].adj) == 0 {
+			continue
+		}
+		feat := geojson.NewPointFeature([]float64{net.nodes[n].lon, net.nodes[n].lat})
+		feat.SetProperty("node_id", n)
+		feat.SetProperty("degree", len(net.nodes[n].adj))
+		fc.AddFeature(feat)
+		numNodes++
+	}
+
+	return fc, numEdges, numNodes
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func TestShapeNetworkExporter(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// C_shp equals A_shp and B_shp, but continues beyond their end
+	feed.Trips["STBA"].Shape = feed.Shapes["C_shp"]
+
+	path := filepath.Join(t.TempDir(), "network.json")
+	ShapeNetworkExporter{OutFile: path, MaxEqDist: 500}.Run(feed)
+
+	json, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edges := make([]*geojson.Feature, 0)
+	for _, f := range fc.Features {
+		if f.Geometry.IsLineString() {
+			edges = append(edges, f)
+		}
+	}
+
+	if len(edges) != 2 {
+		t.Error(len(edges))
+		return
+	}
+
+	for _, e := range edges {
+		routes := e.Properties["routes"].([]interface{})
+		trips := e.Properties["trips"].(float64)
+
+		if len(routes) == 3 && trips == 3 {
+			// the shared part ends at the end of A_shp and B_shp
+			end := e.Geometry.LineString[len(e.Geometry.LineString)-1]
+			start := e.Geometry.LineString[0]
+			if !(FloatEquals(float32(end[1]), 3.5, 0.001) || FloatEquals(float32(start[1]), 3.5, 0.001)) {
+				t.Error(e.Geometry.LineString)
+			}
+		} else if len(routes) != 1 || routes[0] != "STBA" || trips != 1 {
+			t.Error(routes, trips)
+		}
+	}
+}
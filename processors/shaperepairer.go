@@ -0,0 +1,386 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// ShapeRepairer repairs common artifacts in shapes: consecutive duplicate
+// points are removed, and so are spikes and zig-zags, that is, points at
+// which the shape turns back with an angle below MaxSpikeAngle (in degrees).
+// If MaxSpikeLen is > 0, such a turn is only removed if at least one of its
+// legs is not longer than MaxSpikeLen (in meters). As shapes legitimately
+// turn back at dead ends, points within MaxStopDist (in meters) of a stop
+// served on the shape are never removed.
+//
+// Shapes whose direction contradicts the stop order of all trips using them
+// are reversed. If only some of the trips contradict the shape's direction,
+// these trips get a reversed copy of the shape. The measurements of
+// reversed shapes and of the stop times using them are recomputed. If
+// ReportFile is set, the number of repairs per shape is written to a CSV
+// file.
+type ShapeRepairer struct {
+	MaxSpikeAngle float64
+	MaxSpikeLen   float64
+	MaxStopDist   float64
+	ReportFile    string
+}
+
+// Repairs done on a single shape
+type shapeRepairs struct {
+	duplicates int
+	spikes     int
+	reversed   bool
+}
+
+// Run this ShapeRepairer on some feed
+func (sr ShapeRepairer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Repairing shapes... ")
+
+	trips := make(map[*gtfs.Shape][]*gtfs.Trip)
+	for _, t := range feed.Trips {
+		if t.Shape != nil {
+			trips[t.Shape] = append(trips[t.Shape], t)
+		}
+	}
+
+	shapes := make([]*gtfs.Shape, 0, len(feed.Shapes))
+	for _, s := range feed.Shapes {
+		shapes = append(shapes, s)
+	}
+	sort.Slice(shapes, func(i, j int) bool {
+		return shapes[i].Id < shapes[j].Id
+	})
+
+	repairs := make(map[*gtfs.Shape]*shapeRepairs, len(shapes))
+
+	for _, s := range shapes {
+		r := &shapeRepairs{}
+		repairs[s] = r
+		r.duplicates = sr.removeDuplicates(s)
+		r.spikes = sr.removeSpikes(s, sr.servedStops(trips[s]))
+		if r.duplicates > 0 || r.spikes > 0 {
+			for i := range s.Points {
+				s.Points[i].Sequence = uint32(i)
+			}
+		}
+	}
+
+	// detect reversed shapes on the cleaned geometries
+	stm := StopTimeRemeasurer{}
+	stm.buildAllSegments(feed)
+
+	reversed := make([]*gtfs.Shape, 0)
+	copies := make([]*gtfs.Shape, 0)
+
+	for _, s := range shapes {
+		if len(s.Points) < 2 {
+			continue
+		}
+
+		fwd := make([]*gtfs.Trip, 0, len(trips[s]))
+		bwd := make([]*gtfs.Trip, 0)
+		for _, t := range trips[s] {
+			if sr.isReversed(&stm, s, t) {
+				bwd = append(bwd, t)
+			} else {
+				fwd = append(fwd, t)
+			}
+		}
+
+		if len(bwd) == 0 {
+			continue
+		}
+
+		if len(fwd) == 0 {
+			sr.reverse(s, bwd)
+			repairs[s].reversed = true
+			reversed = append(reversed, s)
+			continue
+		}
+
+		// the shape is used in both directions, only reverse it for the
+		// trips contradicting it
+		cp := sr.copyShape(feed, s)
+		sr.reverse(cp, bwd)
+		for _, t := range bwd {
+			t.Shape = cp
+		}
+
+		trips[s] = fwd
+		trips[cp] = bwd
+		repairs[cp] = &shapeRepairs{reversed: true}
+		reversed = append(reversed, cp)
+		copies = append(copies, cp)
+	}
+
+	shapes = append(shapes, copies...)
+
+	if len(reversed) > 0 {
+		// remeasure the reversed shapes and their stop times
+		stm.buildAllSegments(feed)
+		for _, s := range reversed {
+			ShapeRemeasurer{}.remeasure(s)
+			for _, t := range trips[s] {
+				stm.remeasure(t)
+			}
+		}
+	}
+
+	numDups := 0
+	numSpikes := 0
+	numRepaired := 0
+	for _, s := range shapes {
+		r := repairs[s]
+		numDups += r.duplicates
+		numSpikes += r.spikes
+		if r.duplicates > 0 || r.spikes > 0 || r.reversed {
+			numRepaired++
+		}
+	}
+
+	if len(sr.ReportFile) > 0 {
+		if err := sr.writeReport(shapes, repairs); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write shape repair report to %s: %s\n", sr.ReportFile, err.Error())
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d shapes repaired, -%d duplicate points, -%d spikes, %d shapes reversed, +%d reversed shape copies)\n",
+		numRepaired-len(copies),
+		numDups,
+		numSpikes,
+		len(reversed)-len(copies),
+		len(copies))
+}
+
+// Remove consecutive duplicate points, return the number of removed points
+func (sr ShapeRepairer) removeDuplicates(s *gtfs.Shape) int {
+	if len(s.Points) < 2 {
+		return 0
+	}
+
+	ret := s.Points[:1]
+	for i := 1; i < len(s.Points); i++ {
+		last := ret[len(ret)-1]
+		if s.Points[i].Lat == last.Lat && s.Points[i].Lon == last.Lon {
+			// keep the later measurement
+			if s.Points[i].HasDistanceTraveled() {
+				ret[len(ret)-1].Dist_traveled = s.Points[i].Dist_traveled
+			}
+			continue
+		}
+		ret = append(ret, s.Points[i])
+	}
+
+	removed := len(s.Points) - len(ret)
+	s.Points = ret
+	return removed
+}
+
+// Return the positions of all stops served by the given trips
+func (sr ShapeRepairer) servedStops(trips []*gtfs.Trip) [][2]float64 {
+	seen := make(map[*gtfs.Stop]bool)
+	ret := make([][2]float64, 0)
+
+	for _, t := range trips {
+		for _, st := range t.StopTimes {
+			if seen[st.Stop()] {
+				continue
+			}
+			seen[st.Stop()] = true
+			lat, lon := getStopLatLon(st.Stop())
+			ret = append(ret, [2]float64{float64(lat), float64(lon)})
+		}
+	}
+
+	return ret
+}
+
+// Remove spikes and zig-zags, return the number of removed points
+func (sr ShapeRepairer) removeSpikes(s *gtfs.Shape, stops [][2]float64) int {
+	removed := 0
+
+	for changed := true; changed && len(s.Points) > 2; {
+		changed = false
+		ret := s.Points[:1]
+
+		for i := 1; i < len(s.Points)-1; i++ {
+			a := ret[len(ret)-1]
+			p := s.Points[i]
+			b := s.Points[i+1]
+
+			if sr.angle(a, p, b) < sr.MaxSpikeAngle && sr.shortLeg(a, p, b) && !sr.nearStop(p, stops) {
+				removed++
+				changed = true
+				continue
+			}
+
+			ret = append(ret, p)
+		}
+
+		s.Points = append(ret, s.Points[len(s.Points)-1])
+	}
+
+	return removed
+}
+
+// Check if one of the segments a-p and p-b is not longer than MaxSpikeLen
+func (sr ShapeRepairer) shortLeg(a gtfs.ShapePoint, p gtfs.ShapePoint, b gtfs.ShapePoint) bool {
+	if sr.MaxSpikeLen <= 0 {
+		return true
+	}
+	return math.Min(distP(&a, &p), distP(&p, &b)) <= sr.MaxSpikeLen
+}
+
+// Return the angle (in degrees) at p between the segments p-a and p-b
+func (sr ShapeRepairer) angle(a gtfs.ShapePoint, p gtfs.ShapePoint, b gtfs.ShapePoint) float64 {
+	// local equirectangular projection around p
+	c := math.Cos(float64(p.Lat) * DEG_TO_RAD)
+
+	ax := float64(a.Lon-p.Lon) * c
+	ay := float64(a.Lat - p.Lat)
+	bx := float64(b.Lon-p.Lon) * c
+	by := float64(b.Lat - p.Lat)
+
+	la := math.Sqrt(ax*ax + ay*ay)
+	lb := math.Sqrt(bx*bx + by*by)
+
+	if la == 0 || lb == 0 {
+		return 180
+	}
+
+	cos := (ax*bx + ay*by) / (la * lb)
+	return math.Acos(math.Max(-1, math.Min(1, cos))) / DEG_TO_RAD
+}
+
+// Check if a shape point is within MaxStopDist of any of the stops
+func (sr ShapeRepairer) nearStop(p gtfs.ShapePoint, stops [][2]float64) bool {
+	for _, s := range stops {
+		if haversineApprox(float64(p.Lat), float64(p.Lon), s[0], s[1]) <= sr.MaxStopDist {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the stops of a trip can only be projected onto the shape in
+// reverse order
+func (sr ShapeRepairer) isReversed(stm *StopTimeRemeasurer, s *gtfs.Shape, t *gtfs.Trip) bool {
+	return !followsShape(stm, t, s, false) && followsShape(stm, t, s, true)
+}
+
+// Check if the stops of a trip can be projected onto a shape in order, that
+// is, if a candidate projection can be chosen for each stop which is not
+// before (or, if reverse is set, not after) the projection of the previous
+// stop. The earliest such candidate is chosen, which leaves the most room
+// for the following stops. Stops without any candidate are ignored.
+func followsShape(stm *StopTimeRemeasurer, t *gtfs.Trip, s *gtfs.Shape, reverse bool) bool {
+	last := math.Inf(-1)
+
+	for _, st := range t.StopTimes {
+		lat, lon := getStopLatLon(st.Stop())
+		cands := stm.getCands(lat, lon, s)
+		if len(cands) == 0 {
+			continue
+		}
+
+		next := math.Inf(1)
+		for _, c := range cands {
+			pos := float64(c.Seg) + c.Progr
+			if reverse {
+				pos = -pos
+			}
+			if pos >= last && pos < next {
+				next = pos
+			}
+		}
+
+		if math.IsInf(next, 1) {
+			return false
+		}
+
+		last = next
+	}
+
+	return true
+}
+
+// Add a copy of a shape to the feed, and return it
+func (sr ShapeRepairer) copyShape(feed *gtfsparser.Feed, s *gtfs.Shape) *gtfs.Shape {
+	cp := &gtfs.Shape{Points: append(gtfs.ShapePoints(nil), s.Points...)}
+
+	for try := 0; ; try++ {
+		if try == 0 {
+			cp.Id = "rev::" + s.Id
+		} else {
+			cp.Id = "rev" + strconv.Itoa(try) + "::" + s.Id
+		}
+		if _, ok := feed.Shapes[cp.Id]; !ok {
+			break
+		}
+	}
+
+	feed.Shapes[cp.Id] = cp
+
+	return cp
+}
+
+// Reverse a shape, and invalidate its measurements and those of the stop
+// times using it
+func (sr ShapeRepairer) reverse(s *gtfs.Shape, trips []*gtfs.Trip) {
+	n := len(s.Points)
+	for i := 0; i < n/2; i++ {
+		s.Points[i], s.Points[n-1-i] = s.Points[n-1-i], s.Points[i]
+	}
+
+	for i := range s.Points {
+		s.Points[i].Sequence = uint32(i)
+		s.Points[i].Dist_traveled = float32(math.NaN())
+	}
+
+	for _, t := range trips {
+		for i := range t.StopTimes {
+			t.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+		}
+	}
+}
+
+// Write the number of repairs per repaired shape to ReportFile
+func (sr ShapeRepairer) writeReport(shapes []*gtfs.Shape, repairs map[*gtfs.Shape]*shapeRepairs) error {
+	f, err := os.Create(sr.ReportFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"shape_id", "duplicate_points_removed", "spikes_removed", "reversed"})
+
+	for _, s := range shapes {
+		r := repairs[s]
+		if r.duplicates == 0 && r.spikes == 0 && !r.reversed {
+			continue
+		}
+		rev := "0"
+		if r.reversed {
+			rev = "1"
+		}
+		w.Write([]string{s.Id, strconv.Itoa(r.duplicates), strconv.Itoa(r.spikes), rev})
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+)
+
+func TestShapeRepairer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// shape from BEATTY_AIRPORT to STAGECOACH, although trip STBA goes
+	// from STAGECOACH to BEATTY_AIRPORT, with a duplicate point and a spike
+	shp := &gtfs.Shape{Id: "rep", Points: gtfs.ShapePoints{
+		{Lat: 36.868446, Lon: -116.784582, Sequence: 0},
+		{Lat: 36.88, Lon: -116.776, Sequence: 1},
+		{Lat: 36.88, Lon: -116.776, Sequence: 2},
+		{Lat: 36.89, Lon: -116.70, Sequence: 3},
+		{Lat: 36.895, Lon: -116.766, Sequence: 4},
+		{Lat: 36.915682, Lon: -116.751677, Sequence: 5},
+	}}
+
+	feed.Shapes[shp.Id] = shp
+	feed.Trips["STBA"].Shape = shp
+
+	ShapeRepairer{MaxSpikeAngle: 30, MaxStopDist: 50}.Run(feed)
+
+	if len(shp.Points) != 4 {
+		t.Error(shp.Points)
+		return
+	}
+
+	if shp.Points[0].Lat != 36.915682 || shp.Points[3].Lat != 36.868446 || shp.Points[1].Lat != 36.895 {
+		t.Error(shp.Points)
+	}
+
+	trip := feed.Trips["STBA"]
+	if trip.StopTimes[0].Shape_dist_traveled() != 0 {
+		t.Error(trip.StopTimes[0].Shape_dist_traveled())
+	}
+
+	if d := trip.StopTimes[1].Shape_dist_traveled(); !FloatEquals(d, shp.Points[3].Dist_traveled, 0.01) || d < 6000 {
+		t.Error(d, shp.Points[3].Dist_traveled)
+	}
+}
+
+func TestShapeRepairerSpikeLength(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// shape from STAGECOACH to BEATTY_AIRPORT with a short spike of about
+	// 70 meters and a long one of several kilometers
+	shp := &gtfs.Shape{Id: "rep", Points: gtfs.ShapePoints{
+		{Lat: 36.915682, Lon: -116.751677, Sequence: 0},
+		{Lat: 36.895, Lon: -116.766, Sequence: 1},
+		{Lat: 36.8955, Lon: -116.7655, Sequence: 2},
+		{Lat: 36.89, Lon: -116.70, Sequence: 3},
+		{Lat: 36.88, Lon: -116.776, Sequence: 4},
+		{Lat: 36.868446, Lon: -116.784582, Sequence: 5},
+	}}
+
+	feed.Shapes[shp.Id] = shp
+	feed.Trips["STBA"].Shape = shp
+
+	ShapeRepairer{MaxSpikeAngle: 30, MaxSpikeLen: 100, MaxStopDist: 50}.Run(feed)
+
+	if len(shp.Points) != 5 {
+		t.Error(shp.Points)
+		return
+	}
+
+	found := false
+	for _, p := range shp.Points {
+		if p.Lon == -116.70 {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Error("long spike should have been kept")
+	}
+}
+
+func TestShapeRepairerMixedDirections(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// shape from BEATTY_AIRPORT to STAGECOACH
+	shp := &gtfs.Shape{Id: "rep", Points: gtfs.ShapePoints{
+		{Lat: 36.868446, Lon: -116.784582, Sequence: 0},
+		{Lat: 36.88, Lon: -116.776, Sequence: 1},
+		{Lat: 36.895, Lon: -116.766, Sequence: 2},
+		{Lat: 36.915682, Lon: -116.751677, Sequence: 3},
+	}}
+
+	feed.Shapes[shp.Id] = shp
+
+	// STBA goes from STAGECOACH to BEATTY_AIRPORT, BAST the other way round
+	stba := feed.Trips["STBA"]
+	bast := *stba
+	bast.Id = "BAST"
+	bast.StopTimes = gtfs.StopTimes{stba.StopTimes[1], stba.StopTimes[0]}
+	feed.Trips[bast.Id] = &bast
+
+	stba.Shape = shp
+	bast.Shape = shp
+
+	ShapeRepairer{MaxSpikeAngle: 30, MaxStopDist: 50}.Run(feed)
+
+	if bast.Shape != shp || shp.Points[0].Lat != 36.868446 {
+		t.Error("Shape should have been kept for BAST")
+	}
+
+	rev := stba.Shape
+	if rev == shp || rev == nil || rev.Id != "rev::rep" || feed.Shapes["rev::rep"] != rev {
+		t.Error("STBA should use a reversed copy of the shape")
+		return
+	}
+
+	if len(rev.Points) != 4 || rev.Points[0].Lat != 36.915682 || rev.Points[3].Lat != 36.868446 {
+		t.Error(rev.Points)
+	}
+
+	for i, p := range rev.Points {
+		if p.Sequence != uint32(i) {
+			t.Error(rev.Points)
+		}
+	}
+
+	if d := stba.StopTimes[1].Shape_dist_traveled(); !FloatEquals(d, rev.Points[3].Dist_traveled, 0.01) || d < 5000 {
+		t.Error(d, rev.Points[3].Dist_traveled)
+	}
+}
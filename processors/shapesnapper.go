@@ -7,28 +7,130 @@
 package processors
 
 import (
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 )
 
-// ShapeMinimizer minimizes shapes.
+// ShapeSnapper snaps stops to the shapes of the trips serving them. For
+// each stop time whose stop is further than MaxDist (in meters) away from
+// the trip's shape, a new stop on the shape is created. If MoveStops is set
+// and all stop times of a stop would be snapped to the same position, the
+// stop is moved there instead.
+//
+// If SideOffset is not 0, snapped positions are moved SideOffset meters to
+// the right of the travel direction on the shape (or to the left, if
+// SideOffset is negative), to place them on the correct side of the road.
+// If ReportFile is set, all moved and created stops are written to a CSV
+// file.
 type ShapeSnapper struct {
-	MaxDist   float64
-	mercs     map[*gtfs.Shape][][]float64
-	stopMercs map[*gtfs.Stop][2]float64
+	MaxDist    float64
+	MoveStops  bool
+	SideOffset float64
+	ReportFile string
+	mercs      map[*gtfs.Shape][][]float64
+	stopMercs  map[*gtfs.Stop][2]float64
+}
+
+// The position a single stop time would be snapped to
+type snapCand struct {
+	trip *gtfs.Trip
+	i    int
+	lat  float32
+	lon  float32
+	dist float64
 }
 
-// Run this ShapeMinimizer on some feed
+// max distance in meters between the snapped positions of all stop times of
+// a stop for the stop to be moved
+const snapAgreeDist = 5.0
+
+// Run this ShapeSnapper on some feed
 func (sm ShapeSnapper) Run(feed *gtfsparser.Feed) {
 	fmt.Fprintf(os.Stdout, "Snapping stop points to shapes... ")
 
 	orign := len(feed.Stops)
 
-	// build projection cache
+	sm.buildCache(feed)
+
+	cands := make(map[*gtfs.Stop][]snapCand)
+	refs := make(map[*gtfs.Stop]int)
+
+	for _, t := range feed.Trips {
+		for i, st := range t.StopTimes {
+			refs[st.Stop()]++
+
+			if t.Shape == nil {
+				continue
+			}
+
+			lat, lon, d := sm.snapPos(t, i)
+			if d > sm.MaxDist {
+				cands[st.Stop()] = append(cands[st.Stop()], snapCand{t, i, lat, lon, d})
+			}
+		}
+	}
+
+	stops := make([]*gtfs.Stop, 0, len(cands))
+	for s := range cands {
+		stops = append(stops, s)
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Id < stops[j].Id
+	})
+
+	report := [][]string{{"stop_id", "snapped_stop_id", "action", "dist", "stop_lat", "stop_lon"}}
+	moved := 0
+
+	for _, stop := range stops {
+		c := cands[stop]
+
+		if sm.MoveStops && len(c) == refs[stop] && sm.agree(c) {
+			maxDist := 0.0
+			lat := 0.0
+			lon := 0.0
+			for _, cand := range c {
+				lat += float64(cand.lat) / float64(len(c))
+				lon += float64(cand.lon) / float64(len(c))
+				maxDist = math.Max(maxDist, cand.dist)
+			}
+
+			stop.Lat = float32(lat)
+			stop.Lon = float32(lon)
+			moved++
+
+			report = append(report, sm.reportRow(stop, stop, "moved", maxDist))
+			continue
+		}
+
+		for _, cand := range c {
+			newStop := sm.createStop(feed, stop, cand.lat, cand.lon)
+			cand.trip.StopTimes[cand.i].SetStop(newStop)
+
+			report = append(report, sm.reportRow(stop, newStop, "created", cand.dist))
+		}
+	}
+
+	if len(sm.ReportFile) > 0 {
+		if err := sm.writeReport(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write stop snapping report to %s: %s\n", sm.ReportFile, err.Error())
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d stop points [+%.2f%%], %d stops moved)\n",
+		len(feed.Stops)-orign,
+		100.0*float64(len(feed.Stops)-orign)/(float64(orign)+0.001),
+		moved)
+}
+
+// Build the web mercator projection cache for shapes and stops
+func (sm *ShapeSnapper) buildCache(feed *gtfsparser.Feed) {
 	sm.mercs = make(map[*gtfs.Shape][][]float64)
 	sm.stopMercs = make(map[*gtfs.Stop][2]float64)
 
@@ -43,49 +145,118 @@ func (sm ShapeSnapper) Run(feed *gtfsparser.Feed) {
 		x, y := latLngToWebMerc(s.Lat, s.Lon)
 		sm.stopMercs[s] = [2]float64{x, y}
 	}
+}
 
-	for _, t := range feed.Trips {
-		if t.Shape == nil {
-			continue
+// Snap the i-th stop time of trip t to the trip's shape, if it is further
+// away than MaxDist. Return true if the stop time was snapped.
+func (sm *ShapeSnapper) snapStopTime(feed *gtfsparser.Feed, t *gtfs.Trip, i int) bool {
+	lat, lon, d := sm.snapPos(t, i)
+
+	if d <= sm.MaxDist {
+		return false
+	}
+
+	t.StopTimes[i].SetStop(sm.createStop(feed, t.StopTimes[i].Stop(), lat, lon))
+
+	return true
+}
+
+// Return the position the i-th stop time of trip t is snapped to, and the
+// distance between the stop and the shape
+func (sm *ShapeSnapper) snapPos(t *gtfs.Trip, i int) (float32, float32, float64) {
+	st := t.StopTimes[i]
+	x, y, seg := sm.snapTo(st.Stop(), st.Shape_dist_traveled(), t.Shape)
+	snaplat, snaplon := webMercToLatLng(x, y)
+	d := haversineApprox(float64(snaplat), float64(snaplon), float64(st.Stop().Lat), float64(st.Stop().Lon))
+
+	shp := sm.mercs[t.Shape]
+
+	if sm.SideOffset != 0 && seg >= 0 && seg+1 < len(shp) {
+		dx := shp[seg+1][0] - shp[seg][0]
+		dy := shp[seg+1][1] - shp[seg][1]
+		l := math.Sqrt(dx*dx + dy*dy)
+
+		if l > 0 {
+			// web mercator distances are scaled by 1/cos(lat)
+			off := sm.SideOffset / math.Cos(float64(snaplat)*DEG_TO_RAD)
+
+			// right-hand normal of the travel direction
+			snaplat, snaplon = webMercToLatLng(x+dy/l*off, y-dx/l*off)
 		}
+	}
 
-		for i, st := range t.StopTimes {
-			snaplat, snaplon := webMercToLatLng(sm.snapTo(st.Stop(), st.Shape_dist_traveled(), t.Shape))
-			d := haversineApprox(float64(snaplat), float64(snaplon), float64(st.Stop().Lat), float64(st.Stop().Lon))
+	return snaplat, snaplon, d
+}
 
-			if d > sm.MaxDist {
-				newId := sm.freeStopId(feed, "#"+st.Stop().Id)
-
-				newStop := gtfs.Stop{
-					Id:                  newId,
-					Code:                st.Stop().Code,
-					Name:                st.Stop().Name,
-					Desc:                st.Stop().Desc,
-					Lat:                 snaplat,
-					Lon:                 snaplon,
-					Location_type:       st.Stop().Location_type,
-					Wheelchair_boarding: st.Stop().Wheelchair_boarding,
-					Zone_id:             st.Stop().Zone_id,
-					Url:                 st.Stop().Url,
-					Parent_station:      st.Stop().Parent_station,
-					Translations:        st.Stop().Translations,
-					Level:               st.Stop().Level,
-					Platform_code:       st.Stop().Platform_code,
-					Timezone:            st.Stop().Timezone,
-				}
-
-				feed.Stops[newId] = &newStop
-				t.StopTimes[i].SetStop(&newStop)
-			}
+// Check if all snapped positions are within snapAgreeDist of the first one
+func (sm *ShapeSnapper) agree(cands []snapCand) bool {
+	for _, c := range cands[1:] {
+		if haversineApprox(float64(cands[0].lat), float64(cands[0].lon), float64(c.lat), float64(c.lon)) > snapAgreeDist {
+			return false
 		}
 	}
+	return true
+}
 
-	fmt.Fprintf(os.Stdout, "done. (+%d stop points [+%.2f%%])\n",
-		len(feed.Stops)-orign,
-		100.0*float64(len(feed.Stops)-orign)/(float64(orign)+0.001))
+// Create a copy of stop at the given position
+func (sm *ShapeSnapper) createStop(feed *gtfsparser.Feed, stop *gtfs.Stop, lat float32, lon float32) *gtfs.Stop {
+	newId := sm.freeStopId(feed, "#"+stop.Id)
+
+	newStop := gtfs.Stop{
+		Id:                  newId,
+		Code:                stop.Code,
+		Name:                stop.Name,
+		Desc:                stop.Desc,
+		Lat:                 lat,
+		Lon:                 lon,
+		Location_type:       stop.Location_type,
+		Wheelchair_boarding: stop.Wheelchair_boarding,
+		Zone_id:             stop.Zone_id,
+		Url:                 stop.Url,
+		Parent_station:      stop.Parent_station,
+		Translations:        stop.Translations,
+		Level:               stop.Level,
+		Platform_code:       stop.Platform_code,
+		Timezone:            stop.Timezone,
+	}
+
+	x, y := latLngToWebMerc(newStop.Lat, newStop.Lon)
+	sm.stopMercs[&newStop] = [2]float64{x, y}
+
+	feed.Stops[newId] = &newStop
+
+	return &newStop
+}
+
+// Return a row of the snapping report
+func (sm *ShapeSnapper) reportRow(stop *gtfs.Stop, snapped *gtfs.Stop, action string, d float64) []string {
+	return []string{
+		stop.Id,
+		snapped.Id,
+		action,
+		strconv.FormatFloat(d, 'f', 2, 64),
+		strconv.FormatFloat(float64(snapped.Lat), 'f', -1, 32),
+		strconv.FormatFloat(float64(snapped.Lon), 'f', -1, 32),
+	}
+}
+
+// Write the snapping report to ReportFile
+func (sm *ShapeSnapper) writeReport(rows [][]string) error {
+	f, err := os.Create(sm.ReportFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+
+	return w.Error()
 }
 
-func (sm *ShapeSnapper) snapTo(stop *gtfs.Stop, distT float32, shape *gtfs.Shape) (float64, float64) {
+// Snap a stop to a shape, return the snapped position in web mercator
+// coordinates and the index of the segment it was snapped to
+func (sm *ShapeSnapper) snapTo(stop *gtfs.Stop, distT float32, shape *gtfs.Shape) (float64, float64, int) {
 	shp := sm.mercs[shape]
 
 	if float64(distT) != math.NaN() {
@@ -99,7 +270,7 @@ func (sm *ShapeSnapper) snapTo(stop *gtfs.Stop, distT float32, shape *gtfs.Shape
 				x := shp[i][0] + dx*float64(d)
 				y := shp[i][1] + dy*float64(d)
 
-				return x, y
+				return x, y, i
 			}
 		}
 	}
@@ -107,6 +278,7 @@ func (sm *ShapeSnapper) snapTo(stop *gtfs.Stop, distT float32, shape *gtfs.Shape
 	minDist := math.Inf(1)
 	minsx := 0.0
 	minsy := 0.0
+	minSeg := -1
 
 	px := sm.stopMercs[stop][0]
 	py := sm.stopMercs[stop][1]
@@ -118,10 +290,11 @@ func (sm *ShapeSnapper) snapTo(stop *gtfs.Stop, distT float32, shape *gtfs.Shape
 			minsx = sx
 			minsy = sy
 			minDist = dist
+			minSeg = i - 1
 		}
 	}
 
-	return minsx, minsy
+	return minsx, minsy, minSeg
 }
 
 // get a free stop id with the given suffix
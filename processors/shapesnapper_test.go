@@ -0,0 +1,83 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+)
+
+func addSnapShape(feed *gtfsparser.Feed) {
+	// southbound shape about 200 m west of STAGECOACH, used by all trips
+	// serving STAGECOACH
+	shp := &gtfs.Shape{Id: "snap", Points: gtfs.ShapePoints{
+		{Lat: 36.95, Lon: -116.7539, Sequence: 0},
+		{Lat: 36.85, Lon: -116.7539, Sequence: 1},
+	}}
+	feed.Shapes[shp.Id] = shp
+
+	for _, trip := range feed.Trips {
+		trip.Shape = nil
+	}
+	for _, id := range []string{"STBA", "CITY1", "CITY2"} {
+		feed.Trips[id].Shape = shp
+	}
+}
+
+func TestShapeSnapperMoveStops(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+	addSnapShape(feed)
+	stop := feed.Stops["STAGECOACH"]
+	bef := len(feed.Stops)
+
+	ShapeSnapper{MaxDist: 100, MoveStops: true, SideOffset: 3}.Run(feed)
+
+	if feed.Trips["STBA"].StopTimes[0].Stop() != stop || feed.Trips["CITY1"].StopTimes[0].Stop() != stop {
+		t.Error("expected STAGECOACH to be moved")
+	}
+
+	// 3 meters west of the shape, which is on the right side going south
+	if !FloatEquals(stop.Lat, 36.915682, 0.00001) || !FloatEquals(stop.Lon, -116.7539337, 0.000005) {
+		t.Error(stop.Lat, stop.Lon)
+	}
+
+	// stops also served by trips without shapes are not moved
+	if len(feed.Stops) <= bef {
+		t.Error(len(feed.Stops), bef)
+	}
+}
+
+func TestShapeSnapperCreateStops(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+	addSnapShape(feed)
+	stop := feed.Stops["STAGECOACH"]
+
+	ShapeSnapper{MaxDist: 100}.Run(feed)
+
+	if stop.Lat != 36.915682 || stop.Lon != -116.751677 {
+		t.Error(stop.Lat, stop.Lon)
+	}
+
+	snapped := feed.Trips["STBA"].StopTimes[0].Stop()
+	if snapped == stop || snapped.Name != stop.Name || !FloatEquals(snapped.Lon, -116.7539, 0.00002) {
+		t.Error(snapped)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// ShapeTripValidator checks whether the stops of each trip lie along the
+// trip's shape. A trip is inconsistent with its shape if any stop is further
+// than MaxDist (in meters) away from the shape, or if the projections of its
+// stops onto the shape are not monotonically increasing.
+//
+// Depending on Action, inconsistent shape assignments are either dropped
+// ("drop"), stops too far away from an otherwise consistent shape are
+// snapped onto the shape using ShapeSnapper ("snap", non-monotonic shape
+// assignments are still dropped), or inconsistencies are only reported
+// ("report"). If ReportFile is set, all inconsistent trips are written to a
+// CSV file.
+type ShapeTripValidator struct {
+	MaxDist    float64
+	Action     string
+	ReportFile string
+}
+
+// The result of validating a single trip
+type shapeTripCheck struct {
+	trip      *gtfs.Trip
+	shape     *gtfs.Shape
+	maxDist   float64
+	monotonic bool
+	action    string
+}
+
+// Run this ShapeTripValidator on some feed
+func (sv ShapeTripValidator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Validating trip shapes... ")
+
+	stm := StopTimeRemeasurer{}
+	stm.buildAllSegments(feed)
+
+	trips := make([]*gtfs.Trip, 0)
+	for _, t := range feed.Trips {
+		if t.Shape != nil && len(t.Shape.Points) > 1 {
+			trips = append(trips, t)
+		}
+	}
+
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].Id < trips[j].Id
+	})
+
+	bad := make([]*shapeTripCheck, 0)
+	for _, t := range trips {
+		c := sv.check(&stm, t)
+		if c.maxDist > sv.MaxDist || !c.monotonic {
+			bad = append(bad, c)
+		}
+	}
+
+	var snapper *ShapeSnapper
+	if sv.Action == "snap" {
+		snapper = &ShapeSnapper{MaxDist: sv.MaxDist}
+		snapper.buildCache(feed)
+	}
+
+	dropped := 0
+	snapped := 0
+
+	for _, c := range bad {
+		if sv.Action == "report" {
+			c.action = "reported"
+			continue
+		}
+
+		if sv.Action == "snap" && c.monotonic {
+			c.action = "snapped"
+			for i := range c.trip.StopTimes {
+				if snapper.snapStopTime(feed, c.trip, i) {
+					snapped++
+				}
+			}
+			continue
+		}
+
+		c.action = "dropped"
+		c.trip.Shape = nil
+		for i := range c.trip.StopTimes {
+			c.trip.StopTimes[i].SetShape_dist_traveled(float32(math.NaN()))
+		}
+		dropped++
+	}
+
+	if len(sv.ReportFile) > 0 {
+		if err := sv.writeReport(bad); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write trip shape report to %s: %s\n", sv.ReportFile, err.Error())
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d of %d trips inconsistent with their shape [%.2f%%], %d shape assignments dropped, %d stops snapped)\n",
+		len(bad),
+		len(trips),
+		100.0*float64(len(bad))/(float64(len(trips))+0.001),
+		dropped,
+		snapped)
+}
+
+// Check a single trip against its shape
+func (sv ShapeTripValidator) check(stm *StopTimeRemeasurer, t *gtfs.Trip) *shapeTripCheck {
+	ret := &shapeTripCheck{trip: t, shape: t.Shape, monotonic: followsShape(stm, t, t.Shape, false)}
+
+	for _, st := range t.StopTimes {
+		lat, lon := getStopLatLon(st.Stop())
+		cands := stm.getCands(lat, lon, t.Shape)
+
+		if len(cands) == 0 {
+			// stop is outside the search radius of the segment index
+			ret.maxDist = math.Max(ret.maxDist, sv.shapeDist(lat, lon, t.Shape))
+			continue
+		}
+
+		best := math.Inf(1)
+		for _, c := range cands {
+			best = math.Min(best, c.Dist)
+		}
+
+		ret.maxDist = math.Max(ret.maxDist, best)
+	}
+
+	return ret
+}
+
+// Return the distance in meters between a position and a shape
+func (sv ShapeTripValidator) shapeDist(lat float32, lon float32, shp *gtfs.Shape) float64 {
+	ret := math.Inf(1)
+
+	for i := 1; i < len(shp.Points); i++ {
+		a := shp.Points[i-1]
+		b := shp.Points[i]
+		slon, slat := snapTo(float64(lon), float64(lat), float64(a.Lon), float64(a.Lat), float64(b.Lon), float64(b.Lat))
+		ret = math.Min(ret, haversine(float64(lat), float64(lon), slat, slon))
+	}
+
+	return ret
+}
+
+// Write the inconsistent trips to ReportFile
+func (sv ShapeTripValidator) writeReport(bad []*shapeTripCheck) error {
+	f, err := os.Create(sv.ReportFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"trip_id", "shape_id", "max_stop_dist", "monotonic", "action"})
+
+	for _, c := range bad {
+		mon := "0"
+		if c.monotonic {
+			mon = "1"
+		}
+		w.Write([]string{c.trip.Id, c.shape.Id, strconv.FormatFloat(c.maxDist, 'f', 2, 64), mon, c.action})
+	}
+
+	w.Flush()
+	return w.Error()
+}
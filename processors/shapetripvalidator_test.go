@@ -0,0 +1,120 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+)
+
+func TestShapeTripValidator(t *testing.T) {
+	for _, action := range []string{"drop", "snap", "report"} {
+		feed := gtfsparser.NewFeed()
+		opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+		feed.SetParseOpts(opts)
+		if err := feed.Parse("./testfeed"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// the test shapes are far away from the stops
+		for _, trip := range feed.Trips {
+			trip.Shape = nil
+		}
+
+		city1 := feed.Trips["CITY1"]
+		city2 := feed.Trips["CITY2"]
+
+		// shape through the stops of CITY2, in the direction of CITY2
+		shp := &gtfs.Shape{Id: "city"}
+		for i, st := range city2.StopTimes {
+			shp.Points = append(shp.Points, gtfs.ShapePoint{Lat: st.Stop().Lat, Lon: st.Stop().Lon, Sequence: uint32(i), Dist_traveled: float32(math.NaN())})
+		}
+		feed.Shapes[shp.Id] = shp
+
+		// CITY1 runs against the shape
+		city1.Shape = shp
+		city2.Shape = shp
+
+		// NADAV is now ~110 meters away from the shape
+		nadav := feed.Stops["NADAV"]
+		origLat := nadav.Lat
+		nadav.Lat += 0.001
+
+		stops := make([]*gtfs.Stop, len(city2.StopTimes))
+		for i, st := range city2.StopTimes {
+			stops[i] = st.Stop()
+		}
+
+		path := filepath.Join(t.TempDir(), "report.csv")
+		ShapeTripValidator{MaxDist: 30, Action: action, ReportFile: path}.Run(feed)
+
+		switch action {
+		case "drop":
+			if city1.Shape != nil || city2.Shape != nil {
+				t.Error("Shapes of CITY1 and CITY2 should have been dropped")
+			}
+			if !math.IsNaN(float64(city2.StopTimes[0].Shape_dist_traveled())) {
+				t.Error("Measurements of dropped shapes should have been removed")
+			}
+		case "snap":
+			if city1.Shape != nil {
+				t.Error("Shape of CITY1 runs against the trip and should have been dropped")
+			}
+			if city2.Shape != shp {
+				t.Error("Shape of CITY2 should have been kept")
+			}
+			snapped := city2.StopTimes[2].Stop()
+			if snapped == nadav || snapped.Name != nadav.Name || !FloatEquals(snapped.Lat, origLat, 0.0001) {
+				t.Errorf("NADAV should have been snapped onto the shape, got %s at %f", snapped.Id, snapped.Lat)
+			}
+			for _, i := range []int{0, 1, 3, 4} {
+				if city2.StopTimes[i].Stop() != stops[i] {
+					t.Error("Only NADAV should have been snapped")
+				}
+			}
+		case "report":
+			if city1.Shape != shp || city2.Shape != shp || city2.StopTimes[2].Stop() != nadav {
+				t.Error("Trips should not have been changed")
+			}
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		rows, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(rows) != 3 {
+			t.Error(rows)
+			continue
+		}
+
+		exp := map[string][]string{"drop": {"dropped", "dropped"}, "snap": {"dropped", "snapped"}, "report": {"reported", "reported"}}[action]
+
+		if rows[1][0] != "CITY1" || rows[1][3] != "0" || rows[1][4] != exp[0] {
+			t.Error(rows[1])
+		}
+
+		if rows[2][0] != "CITY2" || rows[2][3] != "1" || rows[2][4] != exp[1] {
+			t.Error(rows[2])
+		}
+	}
+}
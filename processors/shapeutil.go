@@ -0,0 +1,54 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"sort"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// Return the routes and the number of trips using each shape, together with
+// all shapes used by trips, sorted by ID
+func shapeUsage(feed *gtfsparser.Feed) (map[*gtfs.Shape]map[*gtfs.Route]bool, map[*gtfs.Shape]int, []*gtfs.Shape) {
+	routes := make(map[*gtfs.Shape]map[*gtfs.Route]bool)
+	trips := make(map[*gtfs.Shape]int)
+
+	for _, t := range feed.Trips {
+		if t.Shape == nil {
+			continue
+		}
+		if routes[t.Shape] == nil {
+			routes[t.Shape] = make(map[*gtfs.Route]bool)
+		}
+		routes[t.Shape][t.Route] = true
+		trips[t.Shape]++
+	}
+
+	shapes := make([]*gtfs.Shape, 0, len(routes))
+	for s := range routes {
+		shapes = append(shapes, s)
+	}
+	sort.Slice(shapes, func(i, j int) bool {
+		return shapes[i].Id < shapes[j].Id
+	})
+
+	return routes, trips, shapes
+}
+
+// Return the routes in a set, sorted by ID
+func sortedRoutes(routes map[*gtfs.Route]bool) []*gtfs.Route {
+	ret := make([]*gtfs.Route, 0, len(routes))
+	for r := range routes {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Id < ret[j].Id
+	})
+	return ret
+}
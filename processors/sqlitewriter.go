@@ -0,0 +1,446 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Page size of written SQLite databases
+const sqlitePageSize = 4096
+
+// A single row of an SQLite table. Values may be nil, int64, float64,
+// string or []byte.
+type sqliteRow struct {
+	rowid  int64
+	values []interface{}
+}
+
+// An entry of the sqlite_schema table
+type sqliteSchemaEntry struct {
+	typ     string
+	name    string
+	tblName string
+	root    int
+	sql     interface{}
+}
+
+// Minimal writer for SQLite database files. Tables are written once, in
+// full, as b-trees, there is no support for updates, free pages or
+// multi-page indices.
+type sqliteDb struct {
+	pages  [][]byte
+	schema []sqliteSchemaEntry
+}
+
+// A child page of an interior b-tree page, with the largest rowid it
+// contains
+type sqliteChild struct {
+	page int
+	key  int64
+}
+
+// Return a new, empty SQLite database
+func newSqliteDb() *sqliteDb {
+	// page 1 holds the database header and the schema table
+	return &sqliteDb{pages: [][]byte{make([]byte, sqlitePageSize)}}
+}
+
+// Add a table created by the statement sql, with rows sorted by rowid
+func (db *sqliteDb) addTable(name string, sql string, rows []sqliteRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].rowid < rows[j].rowid
+	})
+
+	root := db.buildTable(rows, 0)
+	db.schema = append(db.schema, sqliteSchemaEntry{"table", name, name, root, sql})
+}
+
+// Add the automatic index name of table tbl. Each key holds the values of
+// the indexed columns, followed by the rowid.
+func (db *sqliteDb) addAutoIndex(name string, tbl string, keys [][]interface{}) error {
+	sort.Slice(keys, func(i, j int) bool {
+		return sqliteCompare(keys[i], keys[j]) < 0
+	})
+
+	cells := make([][]byte, 0, len(keys))
+	size := 8
+	for _, k := range keys {
+		payload := sqliteRecord(k)
+		if len(payload) > sqliteMaxLocal(false) {
+			return errors.New("SQLite index key too large")
+		}
+		cell := sqliteAppendVarint(nil, uint64(len(payload)))
+		cell = append(cell, payload...)
+		cells = append(cells, cell)
+		size += len(cell) + 2
+	}
+
+	if size > sqlitePageSize {
+		return errors.New("SQLite index too large")
+	}
+
+	root := db.allocPage()
+	db.writePage(root, 0x0A, cells, 0)
+
+	db.schema = append(db.schema, sqliteSchemaEntry{"index", name, tbl, root, nil})
+	return nil
+}
+
+// Write the database to path, with the given application ID and user
+// version in the header
+func (db *sqliteDb) write(path string, appId uint32, userVersion uint32) error {
+	rows := make([]sqliteRow, len(db.schema))
+	for i, e := range db.schema {
+		rows[i] = sqliteRow{int64(i + 1), []interface{}{e.typ, e.name, e.tblName, int64(e.root), e.sql}}
+	}
+
+	db.buildTable(rows, 1)
+
+	h := db.pages[0]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18] = 1
+	h[19] = 1
+	h[21] = 64
+	h[22] = 32
+	h[23] = 32
+	binary.BigEndian.PutUint32(h[24:], 1)
+	binary.BigEndian.PutUint32(h[28:], uint32(len(db.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1)
+	binary.BigEndian.PutUint32(h[44:], 4)
+	binary.BigEndian.PutUint32(h[56:], 1)
+	binary.BigEndian.PutUint32(h[60:], userVersion)
+	binary.BigEndian.PutUint32(h[68:], appId)
+	binary.BigEndian.PutUint32(h[92:], 1)
+	binary.BigEndian.PutUint32(h[96:], 3040001)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, p := range db.pages {
+		if _, err := f.Write(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Allocate a new page and return its number
+func (db *sqliteDb) allocPage() int {
+	db.pages = append(db.pages, make([]byte, sqlitePageSize))
+	return len(db.pages)
+}
+
+// Build a table b-tree from rows sorted by rowid and return its root page.
+// If root is > 0, the root is written to this page.
+func (db *sqliteDb) buildTable(rows []sqliteRow, root int) int {
+	cells := make([][]byte, len(rows))
+	for i, r := range rows {
+		cells[i] = db.tableLeafCell(r.rowid, sqliteRecord(r.values))
+	}
+
+	if db.fits(cells, root, 8) {
+		if root == 0 {
+			root = db.allocPage()
+		}
+		db.writePage(root, 0x0D, cells, 0)
+		return root
+	}
+
+	children := make([]sqliteChild, 0)
+	cur := make([][]byte, 0)
+	used := 8
+
+	for i, c := range cells {
+		if len(cur) > 0 && used+len(c)+2 > sqlitePageSize {
+			page := db.allocPage()
+			db.writePage(page, 0x0D, cur, 0)
+			children = append(children, sqliteChild{page, rows[i-1].rowid})
+			cur = make([][]byte, 0)
+			used = 8
+		}
+		cur = append(cur, c)
+		used += len(c) + 2
+	}
+
+	page := db.allocPage()
+	db.writePage(page, 0x0D, cur, 0)
+	children = append(children, sqliteChild{page, rows[len(rows)-1].rowid})
+
+	return db.buildInterior(children, root)
+}
+
+// Build the interior levels of a table b-tree above children and return
+// the root page
+func (db *sqliteDb) buildInterior(children []sqliteChild, root int) int {
+	for {
+		cells := make([][]byte, 0, len(children)-1)
+		for _, c := range children[:len(children)-1] {
+			cells = append(cells, sqliteInteriorCell(c))
+		}
+
+		if db.fits(cells, root, 12) {
+			if root == 0 {
+				root = db.allocPage()
+			}
+			db.writePage(root, 0x05, cells, children[len(children)-1].page)
+			return root
+		}
+
+		parents := make([]sqliteChild, 0)
+		cur := make([]sqliteChild, 0)
+		used := 12
+
+		for _, c := range children {
+			if len(cur) > 0 {
+				cell := sqliteInteriorCell(cur[len(cur)-1])
+				if used+len(cell)+2 > sqlitePageSize {
+					parents = append(parents, db.writeInterior(cur))
+					cur = make([]sqliteChild, 0)
+					used = 12
+				} else {
+					used += len(cell) + 2
+				}
+			}
+			cur = append(cur, c)
+		}
+
+		parents = append(parents, db.writeInterior(cur))
+		children = parents
+	}
+}
+
+// Write an interior page for children, return it as a child of the next
+// level
+func (db *sqliteDb) writeInterior(children []sqliteChild) sqliteChild {
+	cells := make([][]byte, 0, len(children)-1)
+	for _, c := range children[:len(children)-1] {
+		cells = append(cells, sqliteInteriorCell(c))
+	}
+
+	last := children[len(children)-1]
+	page := db.allocPage()
+	db.writePage(page, 0x05, cells, last.page)
+
+	return sqliteChild{page, last.key}
+}
+
+// Check if cells fit into a single page with a header of length hdr. If
+// root is 1, the page also holds the database header.
+func (db *sqliteDb) fits(cells [][]byte, root int, hdr int) bool {
+	size := hdr
+	if root == 1 {
+		size += 100
+	}
+	for _, c := range cells {
+		size += len(c) + 2
+	}
+	return size <= sqlitePageSize
+}
+
+// Write cells to a b-tree page of the given type
+func (db *sqliteDb) writePage(num int, typ byte, cells [][]byte, right int) {
+	p := db.pages[num-1]
+
+	off := 0
+	if num == 1 {
+		off = 100
+	}
+
+	hdr := 8
+	if typ == 0x05 || typ == 0x02 {
+		hdr = 12
+		binary.BigEndian.PutUint32(p[off+8:], uint32(right))
+	}
+
+	end := sqlitePageSize
+	for i, c := range cells {
+		end -= len(c)
+		copy(p[end:], c)
+		binary.BigEndian.PutUint16(p[off+hdr+2*i:], uint16(end))
+	}
+
+	p[off] = typ
+	binary.BigEndian.PutUint16(p[off+3:], uint16(len(cells)))
+	binary.BigEndian.PutUint16(p[off+5:], uint16(end%65536))
+}
+
+// Return a cell of a table b-tree leaf, spilling large payloads to
+// overflow pages
+func (db *sqliteDb) tableLeafCell(rowid int64, payload []byte) []byte {
+	cell := sqliteAppendVarint(nil, uint64(len(payload)))
+	cell = sqliteAppendVarint(cell, uint64(rowid))
+
+	local := sqliteLocalSize(len(payload), sqliteMaxLocal(true))
+	cell = append(cell, payload[:local]...)
+
+	if local < len(payload) {
+		var first [4]byte
+		binary.BigEndian.PutUint32(first[:], uint32(db.writeOverflow(payload[local:])))
+		cell = append(cell, first[:]...)
+	}
+
+	return cell
+}
+
+// Write data to a chain of overflow pages, return the first page
+func (db *sqliteDb) writeOverflow(data []byte) int {
+	first := 0
+	prev := 0
+
+	for len(data) > 0 {
+		page := db.allocPage()
+		n := copy(db.pages[page-1][4:], data)
+		data = data[n:]
+
+		if prev == 0 {
+			first = page
+		} else {
+			binary.BigEndian.PutUint32(db.pages[prev-1], uint32(page))
+		}
+		prev = page
+	}
+
+	return first
+}
+
+// Return a cell of an interior table b-tree page
+func sqliteInteriorCell(c sqliteChild) []byte {
+	var cell [4]byte
+	binary.BigEndian.PutUint32(cell[:], uint32(c.page))
+	return sqliteAppendVarint(cell[:], uint64(c.key))
+}
+
+// Return the max payload stored directly on a table or index b-tree page
+func sqliteMaxLocal(table bool) int {
+	if table {
+		return sqlitePageSize - 35
+	}
+	return ((sqlitePageSize-12)*64/255 - 23)
+}
+
+// Return the number of payload bytes stored on the b-tree page itself
+func sqliteLocalSize(p int, x int) int {
+	if p <= x {
+		return p
+	}
+	m := (sqlitePageSize-12)*32/255 - 23
+	k := m + (p-m)%(sqlitePageSize-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+// Encode values as an SQLite record
+func sqliteRecord(values []interface{}) []byte {
+	header := make([]byte, 0)
+	body := make([]byte, 0)
+
+	for _, v := range values {
+		var b [8]byte
+		switch v := v.(type) {
+		case nil:
+			header = sqliteAppendVarint(header, 0)
+		case int64:
+			if v == 0 || v == 1 {
+				header = sqliteAppendVarint(header, uint64(8+v))
+				continue
+			}
+			header = sqliteAppendVarint(header, 6)
+			binary.BigEndian.PutUint64(b[:], uint64(v))
+			body = append(body, b[:]...)
+		case float64:
+			header = sqliteAppendVarint(header, 7)
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+			body = append(body, b[:]...)
+		case string:
+			header = sqliteAppendVarint(header, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			header = sqliteAppendVarint(header, uint64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			panic(fmt.Errorf("unsupported SQLite value %v", v))
+		}
+	}
+
+	// the header size includes its own varint
+	hl := len(header) + 1
+	for len(sqliteAppendVarint(nil, uint64(hl)))+len(header) != hl {
+		hl = len(sqliteAppendVarint(nil, uint64(hl))) + len(header)
+	}
+
+	ret := sqliteAppendVarint(nil, uint64(hl))
+	ret = append(ret, header...)
+	return append(ret, body...)
+}
+
+// Compare two index keys of strings and integers
+func sqliteCompare(a []interface{}, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch av := a[i].(type) {
+		case string:
+			bv := b[i].(string)
+			if av < bv {
+				return -1
+			}
+			if av > bv {
+				return 1
+			}
+		case int64:
+			bv := b[i].(int64)
+			if av < bv {
+				return -1
+			}
+			if av > bv {
+				return 1
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// Append an SQLite varint to buf
+func sqliteAppendVarint(buf []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+
+	var b [8]byte
+	n := 0
+	for {
+		b[7-n] = byte(v & 0x7f)
+		if n > 0 {
+			b[7-n] |= 0x80
+		}
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+
+	return append(buf, b[8-n:]...)
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSqliteVarint(t *testing.T) {
+	tests := []struct {
+		v   uint64
+		exp []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x00}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x81, 0x80, 0x00}},
+		{^uint64(0), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		if got := sqliteAppendVarint(nil, test.v); !bytes.Equal(got, test.exp) {
+			t.Errorf("varint %d: expected %x, got %x", test.v, test.exp, got)
+		}
+	}
+}
+
+func TestSqliteRecord(t *testing.T) {
+	rec := sqliteRecord([]interface{}{nil, int64(0), int64(1), int64(-2), 0.5, "ab", []byte{7}})
+
+	exp := []byte{
+		// header size and serial types
+		0x08, 0x00, 0x08, 0x09, 0x06, 0x07, 0x11, 0x0e,
+		// -2 as 64 bit integer
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+		// 0.5
+		0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		'a', 'b', 0x07,
+	}
+
+	if !bytes.Equal(rec, exp) {
+		t.Errorf("expected %x, got %x", exp, rec)
+	}
+}
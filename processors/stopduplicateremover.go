@@ -24,7 +24,9 @@ type StopDuplicateRemover struct {
 	DistThresholdStation float64
 	Fuzzy                bool
 	KeepIFOPT            bool
+	NameNormalizer       *StopNameNormalizer
 	ifoptRegex           *regexp.Regexp
+	names                map[*gtfs.Stop]string
 }
 
 // Run this StopDuplicateRemover on some feed
@@ -33,6 +35,11 @@ func (sdr StopDuplicateRemover) Run(feed *gtfsparser.Feed) {
 	sdr.ifoptRegex = regexp.MustCompile(`(?i)(?:^|#)([A-Za-z]{2}:[A-Za-z0-9_-]+:[A-Za-z0-9:_-]+)`)
 	bef := len(feed.Stops)
 
+	if sdr.NameNormalizer != nil {
+		sdr.NameNormalizer.prepare(feed)
+		sdr.names = sdr.NameNormalizer.normalizedNames(feed.Stops)
+	}
+
 	levels := make(map[*gtfs.Level][]*gtfs.Stop, len(feed.Levels))
 	procedLvls := make(map[*gtfs.Level]bool, len(feed.Levels))
 
@@ -202,6 +209,11 @@ func (sdr StopDuplicateRemover) combineStops(feed *gtfsparser.Feed, stops []*gtf
 		}
 
 		for _, tk := range transfers[s] {
+			if _, ok := feed.Transfers[tk]; !ok {
+				// already moved
+				continue
+			}
+
 			// update the  key
 			tk_new := tk
 
@@ -214,12 +226,13 @@ func (sdr StopDuplicateRemover) combineStops(feed *gtfsparser.Feed, stops []*gtf
 				tk_new.To_stop = ref
 			}
 
-			if _, ok := feed.Transfers[tk_new]; !ok {
-				feed.Transfers[tk_new] = feed.Transfers[tk]
-				delete(feed.Transfers, tk)
-
+			// on collisions, both transfers are merged
+			if moveTransfer(feed, tk, tk_new) {
 				// add new transfer to transfer refs
-				transfers[ref] = append(transfers[ref], tk_new)
+				transfers[tk_new.From_stop] = append(transfers[tk_new.From_stop], tk_new)
+				if tk_new.To_stop != tk_new.From_stop {
+					transfers[tk_new.To_stop] = append(transfers[tk_new.To_stop], tk_new)
+				}
 			}
 		}
 
@@ -238,6 +251,8 @@ func (sdr StopDuplicateRemover) combineStops(feed *gtfsparser.Feed, stops []*gtf
 			}
 		}
 
+		ref.Translations = mergeTranslations(ref.Translations, s.Translations)
+
 		feed.DeleteStop(s.Id)
 	}
 }
@@ -294,7 +309,7 @@ func (sdr StopDuplicateRemover) stopHash(s *gtfs.Stop) uint32 {
 	}
 
 	if !sdr.Fuzzy {
-		h.Write([]byte(s.Name))
+		h.Write([]byte(sdr.stopName(s)))
 	}
 
 	h.Write([]byte(s.Desc))
@@ -362,6 +377,8 @@ func (sdr StopDuplicateRemover) combineLevels(feed *gtfsparser.Feed, levels []*g
 			}
 		}
 
+		ref.Translations = mergeTranslations(ref.Translations, l.Translations)
+
 		feed.DeleteLevel(l.Id)
 	}
 }
@@ -387,6 +404,14 @@ func (sdr StopDuplicateRemover) ifoptEquals(ida string, idb string) bool {
 	return true
 }
 
+// Return the name of a stop used for matching
+func (sdr StopDuplicateRemover) stopName(s *gtfs.Stop) string {
+	if name, ok := sdr.names[s]; ok {
+		return name
+	}
+	return s.Name
+}
+
 // Check if two stops are equal, distances under 1 m count as equal
 func (sdr StopDuplicateRemover) stopEquals(a *gtfs.Stop, b *gtfs.Stop, feed *gtfsparser.Feed) bool {
 	addFldsEq := true
@@ -405,7 +430,7 @@ func (sdr StopDuplicateRemover) stopEquals(a *gtfs.Stop, b *gtfs.Stop, feed *gtf
 	if sdr.Fuzzy {
 		distApprox := distSApprox(a, b)
 		return ((distApprox <= sdr.DistThresholdStop/2 && parentsEqual) || a.Code == b.Code || len(a.Code) == 0 || len(b.Code) == 0) &&
-		((distApprox <= sdr.DistThresholdStop/2 && parentsEqual) || a.Name == b.Name) &&
+		((distApprox <= sdr.DistThresholdStop/2 && parentsEqual) || sdr.stopName(a) == sdr.stopName(b)) &&
 		a.Desc == b.Desc &&
 		a.Zone_id == b.Zone_id &&
 		(a.Url == b.Url || a.Url == nil || b.Url == nil) &&
@@ -420,7 +445,7 @@ func (sdr StopDuplicateRemover) stopEquals(a *gtfs.Stop, b *gtfs.Stop, feed *gtf
 	}
 
 	return addFldsEq && a.Code == b.Code &&
-	a.Name == b.Name &&
+	sdr.stopName(a) == sdr.stopName(b) &&
 	a.Desc == b.Desc &&
 	a.Zone_id == b.Zone_id &&
 	a.Url == b.Url &&
@@ -0,0 +1,299 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// words which are kept lower case during title casing, per language
+var titleCaseExceptions = map[string][]string{
+	"de": {"am", "an", "auf", "bei", "der", "die", "das", "dem", "den", "im", "in", "ob", "unter", "vor", "von", "zum", "zur", "und"},
+	"en": {"and", "at", "by", "in", "of", "on", "the", "to"},
+	"es": {"de", "del", "el", "la", "las", "los", "y"},
+	"fr": {"à", "au", "aux", "de", "des", "du", "en", "et", "la", "le", "les", "sous", "sur"},
+	"it": {"al", "alla", "da", "dei", "del", "della", "di", "e", "in"},
+	"nl": {"aan", "bij", "de", "en", "het", "op", "ten", "ter", "van"},
+}
+
+// StopNameNormalizer normalizes stop names. Whitespace is collapsed,
+// names written entirely in upper or lower case are converted to title
+// case, abbreviations are replaced using the Abbrevs dictionary and city
+// prefixes of the form "City, Name" are removed if TrimCityPrefixes is set
+type StopNameNormalizer struct {
+	Abbrevs          map[string]string
+	Lang             string
+	TrimCityPrefixes bool
+
+	abbrevs      [][]string
+	abbrevRepls  []string
+	exceptions   map[string]bool
+	cityPrefixes map[string]bool
+}
+
+// Run this StopNameNormalizer on some feed
+func (n StopNameNormalizer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Normalizing stop names... ")
+
+	n.prepare(feed)
+
+	changed := 0
+
+	for _, s := range feed.Stops {
+		name := n.normalize(s.Name)
+		if name != s.Name {
+			s.Name = name
+			changed++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d stop names changed [%.2f%%])\n",
+		changed,
+		100.0*float64(changed)/(float64(len(feed.Stops))+0.001))
+}
+
+// Prepare the abbreviation dictionary, the title case exceptions and
+// the city prefixes used in the feed
+func (n *StopNameNormalizer) prepare(feed *gtfsparser.Feed) {
+	n.abbrevs = make([][]string, 0, len(n.Abbrevs))
+	n.abbrevRepls = make([]string, 0, len(n.Abbrevs))
+
+	for from, to := range n.Abbrevs {
+		words := strings.Fields(n.toLower(from))
+		if len(words) == 0 {
+			continue
+		}
+		n.abbrevs = append(n.abbrevs, words)
+		n.abbrevRepls = append(n.abbrevRepls, to)
+	}
+
+	n.exceptions = make(map[string]bool)
+	for _, w := range titleCaseExceptions[strings.ToLower(n.Lang)] {
+		n.exceptions[w] = true
+	}
+
+	n.cityPrefixes = make(map[string]bool)
+
+	if !n.TrimCityPrefixes {
+		return
+	}
+
+	// count how often each prefix is used, and under which prefixes
+	// the remaining names appear
+	prefCount := make(map[string]int)
+	remPrefs := make(map[string]map[string]bool)
+
+	for _, s := range feed.Stops {
+		pref, rem := n.splitCityPrefix(n.normalizeBase(s.Name))
+		if len(pref) == 0 {
+			continue
+		}
+
+		prefCount[pref]++
+
+		if _, ok := remPrefs[rem]; !ok {
+			remPrefs[rem] = make(map[string]bool)
+		}
+		remPrefs[rem][pref] = true
+	}
+
+	for pref, c := range prefCount {
+		// a single occurrence is more likely part of the name itself
+		if c > 1 {
+			n.cityPrefixes[pref] = true
+		}
+	}
+
+	// never trim a prefix if this would make names from
+	// different cities indistinguishable
+	for rem, prefs := range remPrefs {
+		if len(prefs) > 1 {
+			for pref := range prefs {
+				n.cityPrefixes[pref+"\x00"+rem] = false
+			}
+		}
+	}
+}
+
+// Normalize a single name, without city prefix trimming
+func (n *StopNameNormalizer) normalizeBase(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+
+	if n.needsTitleCase(name) {
+		name = n.titleCase(name)
+	}
+
+	return n.replaceAbbrevs(name)
+}
+
+// Normalize a single name
+func (n *StopNameNormalizer) normalize(name string) string {
+	name = n.normalizeBase(name)
+
+	if pref, rem := n.splitCityPrefix(name); len(pref) > 0 && n.cityPrefixes[pref] {
+		if keep, ok := n.cityPrefixes[pref+"\x00"+rem]; !ok || keep {
+			name = rem
+		}
+	}
+
+	return name
+}
+
+// Split a name of the form "City, Name" into "City" and "Name"
+func (n *StopNameNormalizer) splitCityPrefix(name string) (string, string) {
+	i := strings.Index(name, ",")
+	if i < 1 {
+		return "", name
+	}
+
+	pref := strings.TrimSpace(name[:i])
+	rem := strings.TrimSpace(name[i+1:])
+
+	if len(pref) == 0 || len(rem) == 0 {
+		return "", name
+	}
+
+	return pref, rem
+}
+
+// True if the name is written entirely in upper or entirely in lower case
+func (n *StopNameNormalizer) needsTitleCase(name string) bool {
+	hasUpper := false
+	hasLower := false
+
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			hasUpper = true
+		} else if unicode.IsLower(r) {
+			hasLower = true
+		}
+	}
+
+	return hasUpper != hasLower
+}
+
+// Convert a name to title case, keeping language-specific exceptions in
+// lower case
+func (n *StopNameNormalizer) titleCase(name string) string {
+	words := strings.Split(n.toLower(name), " ")
+
+	for i, w := range words {
+		if i > 0 && n.exceptions[w] {
+			continue
+		}
+
+		words[i] = n.titleCaseWord(w)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Upper case the first letter of each part of a (possibly hyphenated) word
+func (n *StopNameNormalizer) titleCaseWord(w string) string {
+	ret := []rune(w)
+	first := true
+
+	for i, r := range ret {
+		if unicode.IsLetter(r) {
+			if first {
+				ret[i] = n.toUpperRune(r)
+			}
+			first = false
+		} else if unicode.IsDigit(r) {
+			// keep ordinals like "5th" lower case
+			first = false
+		} else if r == '-' || r == '/' || r == '(' || r == '.' {
+			first = true
+		}
+	}
+
+	return string(ret)
+}
+
+// Replace abbreviations using the dictionary, longest match first
+func (n *StopNameNormalizer) replaceAbbrevs(name string) string {
+	if len(n.abbrevs) == 0 {
+		return name
+	}
+
+	words := strings.Split(name, " ")
+	ret := make([]string, 0, len(words))
+
+	for i := 0; i < len(words); {
+		best := -1
+		bestLen := 0
+		trail := ""
+
+		for j, abbr := range n.abbrevs {
+			if len(abbr) <= bestLen || i+len(abbr) > len(words) {
+				continue
+			}
+
+			match := true
+			curTrail := ""
+			for k, aw := range abbr {
+				w := n.toLower(words[i+k])
+				if k == len(abbr)-1 {
+					// allow trailing punctuation after the last word
+					trimmed := strings.TrimRight(w, ",;")
+					curTrail = w[len(trimmed):]
+					w = trimmed
+				}
+				if w != aw {
+					match = false
+					break
+				}
+			}
+
+			if match {
+				best = j
+				bestLen = len(abbr)
+				trail = curTrail
+			}
+		}
+
+		if best == -1 {
+			ret = append(ret, words[i])
+			i++
+			continue
+		}
+
+		ret = append(ret, n.abbrevRepls[best]+trail)
+		i += bestLen
+	}
+
+	return strings.Join(strings.Fields(strings.Join(ret, " ")), " ")
+}
+
+func (n *StopNameNormalizer) toLower(s string) string {
+	if n.Lang == "tr" || n.Lang == "az" {
+		return strings.ToLowerSpecial(unicode.TurkishCase, s)
+	}
+	return strings.ToLower(s)
+}
+
+func (n *StopNameNormalizer) toUpperRune(r rune) rune {
+	if n.Lang == "tr" || n.Lang == "az" {
+		return unicode.TurkishCase.ToUpper(r)
+	}
+	return unicode.ToUpper(r)
+}
+
+// Return a map of normalized names for all stops
+func (n *StopNameNormalizer) normalizedNames(stops map[string]*gtfs.Stop) map[*gtfs.Stop]string {
+	ret := make(map[*gtfs.Stop]string, len(stops))
+	for _, s := range stops {
+		ret[s] = n.normalize(s.Name)
+	}
+	return ret
+}
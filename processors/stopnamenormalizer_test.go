@@ -0,0 +1,55 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestStopNameNormalizer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	names := map[string]string{
+		"a": "FREIBURG, HAUPTBAHNHOF",
+		"b": "Freiburg, Bertoldsbrunnen",
+		"c": "Basel, Hbf",
+		"d": "  Main   St.  ",
+		"e": "platz der alten synagoge",
+		"f": "Emmendingen, Bahnhof",
+		"g": "Denzlingen, Bahnhof",
+	}
+
+	for id, name := range names {
+		feed.Stops[id] = &gtfs.Stop{Id: id, Name: name}
+	}
+
+	n := StopNameNormalizer{
+		Abbrevs:          map[string]string{"hbf": "Hauptbahnhof", "st.": "Street"},
+		Lang:             "de",
+		TrimCityPrefixes: true,
+	}
+
+	n.Run(feed)
+
+	expected := map[string]string{
+		"a": "Freiburg, Hauptbahnhof",
+		"b": "Bertoldsbrunnen",
+		"c": "Basel, Hauptbahnhof",
+		"d": "Main Street",
+		"e": "Platz der Alten Synagoge",
+		"f": "Emmendingen, Bahnhof",
+		"g": "Denzlingen, Bahnhof",
+	}
+
+	for id, name := range expected {
+		if feed.Stops[id].Name != name {
+			t.Error("Expected '" + name + "' for stop " + id + ", got '" + feed.Stops[id].Name + "'")
+		}
+	}
+}
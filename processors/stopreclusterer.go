@@ -63,7 +63,21 @@ type StopReclusterer struct {
 	DistThreshold     float64
 	NameSimiThreshold float64
 	GridCellSize      float64
+	NameNormalizer    *StopNameNormalizer
+	NameFolder        *NameFolder
+	StopWords         []string
+	ForceMerge        [][2]string
+	NeverMerge        [][2]string
+	ReportGeoJSON     string
+	ReportCSV         string
 	splitregex        *regexp.Regexp
+	names             map[*gtfs.Stop]string
+	stopWords         map[string]bool
+
+	// manual overrides
+	forceMerge   map[*gtfs.Stop][]*gtfs.Stop
+	neverMerge   map[*gtfs.Stop]map[*gtfs.Stop]bool
+	stopClusters map[*gtfs.Stop]int
 
 	// TF-IDF stuff
 	wordscores []float32
@@ -80,6 +94,20 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 
 	m.splitregex = regexp.MustCompile(`[^\pL]`)
 
+	if m.NameNormalizer != nil {
+		m.NameNormalizer.prepare(feed)
+		m.names = m.NameNormalizer.normalizedNames(feed.Stops)
+	}
+
+	// stop words are compared against tokens, so they have to be
+	// folded the same way
+	m.stopWords = make(map[string]bool, len(m.StopWords))
+	for _, w := range m.StopWords {
+		for tok := range m.tokenize(w) {
+			m.stopWords[tok] = true
+		}
+	}
+
 	clusters := make([]*StopCluster, 0)
 
 	// maps from stops to their parent cluster id
@@ -127,6 +155,25 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 		}
 	}
 
+	// maps from stops to the cluster they are currently in
+	m.stopClusters = make(map[*gtfs.Stop]int, len(feed.Stops))
+	for cId, cl := range clusters {
+		for _, s := range cl.Parents {
+			m.stopClusters[s] = cId
+		}
+		for _, s := range cl.Childs {
+			m.stopClusters[s] = cId
+		}
+	}
+
+	m.buildOverrides(feed)
+
+	// minimum similarity at which merges into each cluster happened
+	mergeSimis := make([]float32, len(clusters))
+	for cId := range mergeSimis {
+		mergeSimis[cId] = -1
+	}
+
 	// geographical grid for faster merge cluster candidate retrieval
 	m.idx = NewStopClusterIdx(clusters, m.GridCellSize, m.GridCellSize)
 
@@ -183,6 +230,15 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 			}
 		}
 
+		for _, s := range clusters[top.value].Parents {
+			m.stopClusters[s] = neigh.id
+		}
+		for _, s := range clusters[top.value].Childs {
+			m.stopClusters[s] = neigh.id
+		}
+
+		mergeSimis[neigh.id] = minMergeSimi(minMergeSimi(mergeSimis[neigh.id], mergeSimis[top.value]), neigh.simi)
+
 		// merge clusters
 		clusters[neigh.id].Parents = append(clusters[neigh.id].Parents, clusters[top.value].Parents...)
 		clusters[neigh.id].Childs = append(clusters[neigh.id].Childs, clusters[top.value].Childs...)
@@ -200,9 +256,11 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 		m.updateNeighs(neigh.id, clusters, neighs, &pq)
 	}
 
+	decisions := make([]clusterDecision, 0)
+
 	// translate the new cluster into the stop relationship
 	newl := 0 // keep count of the new clusters
-	for _, cl := range clusters {
+	for cId, cl := range clusters {
 		// there might now be empty clusters, skip them
 		if len(cl.Childs) == 0 && len(cl.Parents) == 0 {
 			continue
@@ -213,17 +271,23 @@ func (m StopReclusterer) Run(feed *gtfsparser.Feed) {
 			continue
 		}
 
-		m.writeCluster(cl, feed)
+		decision := m.getDecision(cl, mergeSimis[cId])
+		decision.parent, decision.generated = m.writeCluster(cl, feed)
+		decisions = append(decisions, decision)
 	}
 
+	m.writeReports(decisions)
+
 	fmt.Fprintf(os.Stdout, "done. (-%d clusters) [-%.2f%%]\n", (len(clusters) - newl), 100.0*float64(len(clusters)-newl)/(float64(len(clusters))+0.001))
 }
 
-func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
+func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) (*gtfs.Stop, bool) {
 	var parent *gtfs.Stop
+	generated := false
 
 	if len(cl.Childs) > 1 && len(cl.Parents) == 0 {
 		parent = m.createParent(cl.Childs, feed)
+		generated = true
 	} else {
 		//  take the parent with the best overall similarity
 		parent = nil
@@ -253,7 +317,11 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 			continue
 		}
 
-		for tk, tv := range feed.Transfers {
+		for tk := range feed.Transfers {
+			if tk.From_stop != st && tk.To_stop != st {
+				continue
+			}
+
 			tk_new := tk
 			if tk.From_stop == st {
 				tk_new.From_stop = parent
@@ -262,10 +330,8 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 				tk_new.To_stop = parent
 			}
 
-			if _, ok := feed.Transfers[tk_new]; !ok {
-				feed.Transfers[tk_new] = tv
-				delete(feed.Transfers, tk)
-			}
+			// on collisions, both transfers are merged
+			moveTransfer(feed, tk, tk_new)
 		}
 
 		for _, p := range feed.Pathways {
@@ -277,8 +343,14 @@ func (m *StopReclusterer) writeCluster(cl *StopCluster, feed *gtfsparser.Feed) {
 			}
 		}
 
+		if parent != nil {
+			parent.Translations = mergeTranslations(parent.Translations, st.Translations)
+		}
+
 		feed.DeleteStop(st.Id)
 	}
+
+	return parent, generated
 }
 
 // Create a parent for clusters without explicit parent stop
@@ -412,6 +484,17 @@ func (m *StopReclusterer) getNearestClusters(cId int, clusters []*StopCluster) [
 
 	neighs := m.idx.GetNeighbors(cId, clusters[cId], maxDist)
 
+	// clusters containing force-merge partners are always candidates
+	for _, sts := range [][]*gtfs.Stop{clusters[cId].Parents, clusters[cId].Childs} {
+		for _, st := range sts {
+			for _, partner := range m.forceMerge[st] {
+				if ncId := m.stopClusters[partner]; ncId != cId {
+					neighs[ncId] = true
+				}
+			}
+		}
+	}
+
 	for ncId := range neighs {
 		ret = append(ret, ClusterCand{ncId, m.clusterSimi(clusters[cId], clusters[ncId])})
 	}
@@ -420,6 +503,33 @@ func (m *StopReclusterer) getNearestClusters(cId int, clusters []*StopCluster) [
 }
 
 func (m *StopReclusterer) clusterSimi(a *StopCluster, b *StopCluster) float32 {
+	if len(m.forceMerge) > 0 || len(m.neverMerge) > 0 {
+		forced := false
+		for _, stsA := range [][]*gtfs.Stop{a.Parents, a.Childs} {
+			for _, stA := range stsA {
+				for _, stsB := range [][]*gtfs.Stop{b.Parents, b.Childs} {
+					for _, stB := range stsB {
+						if m.neverMerge[stA][stB] {
+							return 0
+						}
+						if !forced {
+							for _, partner := range m.forceMerge[stA] {
+								if partner == stB {
+									forced = true
+									break
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+
+		if forced {
+			return 1
+		}
+	}
+
 	ret := float32(0.0)
 	c := 0
 	for _, stA := range a.Childs {
@@ -485,7 +595,7 @@ func (m *StopReclusterer) buildTfIdfScores(stops map[string]*gtfs.Stop) {
 	m.tokens = make(map[*gtfs.Stop]map[string]int)
 
 	for _, st := range stops {
-		tokens := m.tokenize(st.Name)
+		tokens := m.tokenize(m.stopName(st))
 		dl := 0
 		for token := range tokens {
 			dl++
@@ -509,7 +619,7 @@ func (m *StopReclusterer) getTokenVec(stop *gtfs.Stop) (map[int]float64, int) {
 		return vec, len(m.tokens[stop])
 	}
 
-	tokens := m.tokenize(stop.Name)
+	tokens := m.tokenize(m.stopName(stop))
 	ret := make(map[int]float64, 0)
 
 	for token, count := range tokens {
@@ -523,12 +633,35 @@ func (m *StopReclusterer) getTokenVec(stop *gtfs.Stop) (map[int]float64, int) {
 	return ret, len(tokens)
 }
 
+// Return the name of a stop used for matching
+func (m *StopReclusterer) stopName(s *gtfs.Stop) string {
+	if name, ok := m.names[s]; ok {
+		return name
+	}
+	return s.Name
+}
+
 func (m *StopReclusterer) tokenize(s string) map[string]int {
 	ret := make(map[string]int)
+
+	if m.NameFolder != nil {
+		s = m.NameFolder.Fold(s)
+	}
+
 	s = strings.ToUpper(s)
 	tokens := m.splitregex.Split(s, -1)
+
+	// only drop stop words if they are not the only tokens
+	onlyStopWords := true
+	for _, tok := range tokens {
+		if tok != "" && !m.stopWords[tok] {
+			onlyStopWords = false
+			break
+		}
+	}
+
 	for _, tok := range tokens {
-		if tok == "" {
+		if tok == "" || (!onlyStopWords && m.stopWords[tok]) {
 			continue
 		}
 		if _, ok := ret[tok]; ok {
@@ -0,0 +1,72 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestStopReclustererOverrides(t *testing.T) {
+	for _, override := range []bool{false, true} {
+		feed := gtfsparser.NewFeed()
+		opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+		feed.SetParseOpts(opts)
+
+		e := feed.Parse("./testfeed")
+
+		if e != nil {
+			t.Error(e)
+			return
+		}
+
+		// a second stop with the same name 12 meters away from NADAV
+		feed.Stops["NADAV2"] = &gtfs.Stop{Id: "NADAV2", Name: "North Ave / D Ave N (Demo)", Lat: 36.9150, Lon: -116.76821}
+
+		proc := StopReclusterer{
+			DistThreshold:     75,
+			NameSimiThreshold: 0.55,
+			GridCellSize:      10000,
+		}
+
+		if override {
+			// DADAN is ~600 meters away from NADAV
+			proc.ForceMerge = [][2]string{{"NADAV", "DADAN"}, {"NADAV", "UNKNOWN"}}
+			proc.NeverMerge = [][2]string{{"NADAV", "NADAV2"}}
+		}
+
+		proc.Run(feed)
+
+		nadav := feed.Stops["NADAV"]
+		nadav2 := feed.Stops["NADAV2"]
+		dadan := feed.Stops["DADAN"]
+
+		if nadav.Parent_station == nil {
+			t.Errorf("NADAV should have been clustered (override: %t)", override)
+			continue
+		}
+
+		if !override {
+			if nadav2.Parent_station != nadav.Parent_station {
+				t.Error("NADAV and NADAV2 should have been clustered together")
+			}
+			if dadan.Parent_station != nil {
+				t.Error("DADAN should not have been clustered")
+			}
+			continue
+		}
+
+		if dadan.Parent_station != nadav.Parent_station {
+			t.Error("NADAV and DADAN are force-merged")
+		}
+
+		if nadav2.Parent_station == nadav.Parent_station {
+			t.Error("NADAV and NADAV2 must never be merged")
+		}
+	}
+}
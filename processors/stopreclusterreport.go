@@ -0,0 +1,221 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// A single clustering decision made by the StopReclusterer
+type clusterDecision struct {
+	members   []*gtfs.Stop
+	simis     []float32
+	mergeSimi float32
+	parent    *gtfs.Stop
+	generated bool
+}
+
+func minMergeSimi(a, b float32) float32 {
+	if a < 0 {
+		return b
+	}
+	if b < 0 || a < b {
+		return a
+	}
+	return b
+}
+
+// Build the force-merge and never-merge lookups from the stop id pairs
+func (m *StopReclusterer) buildOverrides(feed *gtfsparser.Feed) {
+	m.forceMerge = make(map[*gtfs.Stop][]*gtfs.Stop)
+	m.neverMerge = make(map[*gtfs.Stop]map[*gtfs.Stop]bool)
+
+	for _, pair := range m.ForceMerge {
+		a, okA := feed.Stops[pair[0]]
+		b, okB := feed.Stops[pair[1]]
+		if !okA || !okB {
+			fmt.Fprintf(os.Stderr, "Warning: force-merge pair (%s, %s) references unknown stop, ignoring.\n", pair[0], pair[1])
+			continue
+		}
+		m.forceMerge[a] = append(m.forceMerge[a], b)
+		m.forceMerge[b] = append(m.forceMerge[b], a)
+	}
+
+	for _, pair := range m.NeverMerge {
+		a, okA := feed.Stops[pair[0]]
+		b, okB := feed.Stops[pair[1]]
+		if !okA || !okB {
+			fmt.Fprintf(os.Stderr, "Warning: never-merge pair (%s, %s) references unknown stop, ignoring.\n", pair[0], pair[1])
+			continue
+		}
+		if _, ok := m.neverMerge[a]; !ok {
+			m.neverMerge[a] = make(map[*gtfs.Stop]bool)
+		}
+		if _, ok := m.neverMerge[b]; !ok {
+			m.neverMerge[b] = make(map[*gtfs.Stop]bool)
+		}
+		m.neverMerge[a][b] = true
+		m.neverMerge[b][a] = true
+	}
+}
+
+// Collect the members of a cluster and their average similarity to the
+// other members
+func (m *StopReclusterer) getDecision(cl *StopCluster, mergeSimi float32) clusterDecision {
+	ret := clusterDecision{mergeSimi: mergeSimi}
+
+	if len(m.ReportGeoJSON) == 0 && len(m.ReportCSV) == 0 {
+		return ret
+	}
+
+	ret.members = append(ret.members, cl.Parents...)
+	ret.members = append(ret.members, cl.Childs...)
+	ret.simis = make([]float32, len(ret.members))
+
+	for i, a := range ret.members {
+		for j, b := range ret.members {
+			if i != j {
+				ret.simis[i] += m.stopSimi(a, b)
+			}
+		}
+		if len(ret.members) > 1 {
+			ret.simis[i] /= float32(len(ret.members) - 1)
+		}
+	}
+
+	return ret
+}
+
+// Returns the role a member stop has in the final cluster
+func (d *clusterDecision) action(st *gtfs.Stop) string {
+	if st == d.parent {
+		return "parent"
+	}
+	if st.Location_type == 1 {
+		return "removed"
+	}
+	if st.Location_type == 4 {
+		return "boarding_area"
+	}
+	return "child"
+}
+
+// Write the clustering decisions to the requested report files
+func (m *StopReclusterer) writeReports(decisions []clusterDecision) {
+	if len(m.ReportCSV) > 0 {
+		if err := m.writeCSVReport(decisions); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write stop cluster report to %s: %s\n", m.ReportCSV, err.Error())
+		}
+	}
+
+	if len(m.ReportGeoJSON) > 0 {
+		if err := m.writeGeoJSONReport(decisions); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write stop cluster report to %s: %s\n", m.ReportGeoJSON, err.Error())
+		}
+	}
+}
+
+func (m *StopReclusterer) writeCSVReport(decisions []clusterDecision) error {
+	f, err := os.Create(m.ReportCSV)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"cluster_id", "parent_id", "parent_name", "parent_generated", "merge_similarity", "stop_id", "stop_name", "location_type", "action", "similarity", "dist_to_parent"})
+
+	for cId, d := range decisions {
+		if d.parent == nil {
+			continue
+		}
+
+		mergeSimi := ""
+		if d.mergeSimi >= 0 {
+			mergeSimi = strconv.FormatFloat(float64(d.mergeSimi), 'f', 4, 32)
+		}
+
+		for i, st := range d.members {
+			lat, lon := getStopLatLon(st)
+			w.Write([]string{
+				strconv.Itoa(cId),
+				d.parent.Id,
+				d.parent.Name,
+				strconv.FormatBool(d.generated),
+				mergeSimi,
+				st.Id,
+				st.Name,
+				strconv.Itoa(int(st.Location_type)),
+				d.action(st),
+				strconv.FormatFloat(float64(d.simis[i]), 'f', 4, 32),
+				strconv.FormatFloat(haversine(float64(lat), float64(lon), float64(d.parent.Lat), float64(d.parent.Lon)), 'f', 2, 64),
+			})
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func (m *StopReclusterer) writeGeoJSONReport(decisions []clusterDecision) error {
+	fc := geojson.NewFeatureCollection()
+
+	for cId, d := range decisions {
+		if d.parent == nil {
+			continue
+		}
+
+		maxDist := 0.0
+
+		for i, st := range d.members {
+			lat, lon := getStopLatLon(st)
+			dist := haversine(float64(lat), float64(lon), float64(d.parent.Lat), float64(d.parent.Lon))
+			if dist > maxDist {
+				maxDist = dist
+			}
+
+			if st == d.parent {
+				continue
+			}
+
+			feat := geojson.NewLineStringFeature([][]float64{{float64(d.parent.Lon), float64(d.parent.Lat)}, {float64(lon), float64(lat)}})
+			feat.SetProperty("cluster_id", cId)
+			feat.SetProperty("stop_id", st.Id)
+			feat.SetProperty("stop_name", st.Name)
+			feat.SetProperty("action", d.action(st))
+			feat.SetProperty("similarity", d.simis[i])
+			feat.SetProperty("dist_to_parent", dist)
+			fc.AddFeature(feat)
+		}
+
+		feat := geojson.NewPointFeature([]float64{float64(d.parent.Lon), float64(d.parent.Lat)})
+		feat.SetProperty("cluster_id", cId)
+		feat.SetProperty("stop_id", d.parent.Id)
+		feat.SetProperty("stop_name", d.parent.Name)
+		feat.SetProperty("parent_generated", d.generated)
+		feat.SetProperty("members", len(d.members))
+		if d.mergeSimi >= 0 {
+			feat.SetProperty("merge_similarity", d.mergeSimi)
+		}
+		feat.SetProperty("max_dist", maxDist)
+		fc.AddFeature(feat)
+	}
+
+	json, err := fc.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(m.ReportGeoJSON, json, 0644)
+}
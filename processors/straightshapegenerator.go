@@ -0,0 +1,181 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// StraightShapeGenerator generates shapes for trips without a shape by
+// connecting the coordinates of their stops with straight lines. Trips with
+// the same stop sequence share the same shape. If MaxSegLen is > 0, segments
+// longer than MaxSegLen are densified by interpolating points along the
+// great circle between the stops. Measurements (in meters) are written to
+// both the generated shape and the trip's stop times.
+type StraightShapeGenerator struct {
+	MaxSegLen float64 // in meters
+}
+
+// Run this StraightShapeGenerator on some feed
+func (sg StraightShapeGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating straight-line shapes... ")
+
+	trips := make([]*gtfs.Trip, 0)
+	for _, t := range feed.Trips {
+		if t.Shape != nil || len(t.StopTimes) < 2 {
+			continue
+		}
+		trips = append(trips, t)
+	}
+
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].Id < trips[j].Id
+	})
+
+	bef := len(feed.Shapes)
+	cache := make(map[string]*gtfs.Shape)
+	dists := make(map[string][]float64)
+	gen := 0
+
+	for _, t := range trips {
+		key := stopSeqKey(t)
+
+		shp, ok := cache[key]
+		if !ok {
+			var d []float64
+			shp, d = sg.genShape(t)
+			if shp != nil {
+				for try := 0; ; try++ {
+					if try == 0 {
+						shp.Id = "straight::" + t.Id
+					} else {
+						shp.Id = "straight" + strconv.Itoa(try) + "::" + t.Id
+					}
+					if _, ok := feed.Shapes[shp.Id]; !ok {
+						break
+					}
+				}
+				feed.Shapes[shp.Id] = shp
+			}
+			cache[key] = shp
+			dists[key] = d
+		}
+
+		if shp == nil {
+			continue
+		}
+
+		t.Shape = shp
+		for i := range t.StopTimes {
+			t.StopTimes[i].SetShape_dist_traveled(float32(dists[key][i]))
+		}
+		gen++
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d shapes for %d trips)\n",
+		len(feed.Shapes)-bef,
+		gen)
+}
+
+// Generate the straight-line shape (without ID) for the stop sequence of a
+// trip, and return it together with the measurement of each stop time. If
+// all stops are at the same position, nil is returned.
+func (sg StraightShapeGenerator) genShape(t *gtfs.Trip) (*gtfs.Shape, []float64) {
+	shp := &gtfs.Shape{Points: make(gtfs.ShapePoints, 0, len(t.StopTimes))}
+	dists := make([]float64, len(t.StopTimes))
+	total := 0.0
+
+	addPoint := func(lat float64, lon float64) {
+		if len(shp.Points) > 0 {
+			last := shp.Points[len(shp.Points)-1]
+			d := haversine(float64(last.Lat), float64(last.Lon), lat, lon)
+			if d == 0 {
+				return
+			}
+			total += d
+		}
+		shp.Points = append(shp.Points, gtfs.ShapePoint{
+			Lat:           float32(lat),
+			Lon:           float32(lon),
+			Sequence:      uint32(len(shp.Points)),
+			Dist_traveled: float32(total),
+		})
+	}
+
+	lat, lon := getStopLatLon(t.StopTimes[0].Stop())
+	addPoint(float64(lat), float64(lon))
+
+	for i := 1; i < len(t.StopTimes); i++ {
+		prevLat, prevLon := getStopLatLon(t.StopTimes[i-1].Stop())
+		lat, lon := getStopLatLon(t.StopTimes[i].Stop())
+
+		if sg.MaxSegLen > 0 {
+			for _, p := range sg.interpolate(float64(prevLat), float64(prevLon), float64(lat), float64(lon)) {
+				addPoint(p[0], p[1])
+			}
+		}
+
+		addPoint(float64(lat), float64(lon))
+		dists[i] = total
+	}
+
+	if len(shp.Points) < 2 {
+		return nil, nil
+	}
+
+	return shp, dists
+}
+
+// Return the intermediate points on the great circle between A and B, such
+// that no resulting segment is longer than MaxSegLen
+func (sg StraightShapeGenerator) interpolate(latA float64, lonA float64, latB float64, lonB float64) [][2]float64 {
+	d := haversine(latA, lonA, latB, lonB)
+	n := int(math.Ceil(d / sg.MaxSegLen))
+
+	if n < 2 {
+		return nil
+	}
+
+	// angular distance
+	delta := d / 6378137.0
+	sinDelta := math.Sin(delta)
+
+	if sinDelta == 0 {
+		return nil
+	}
+
+	phiA := latA * DEG_TO_RAD
+	lamA := lonA * DEG_TO_RAD
+	phiB := latB * DEG_TO_RAD
+	lamB := lonB * DEG_TO_RAD
+
+	ret := make([][2]float64, 0, n-1)
+
+	for k := 1; k < n; k++ {
+		f := float64(k) / float64(n)
+		a := math.Sin((1-f)*delta) / sinDelta
+		b := math.Sin(f*delta) / sinDelta
+
+		x := a*math.Cos(phiA)*math.Cos(lamA) + b*math.Cos(phiB)*math.Cos(lamB)
+		y := a*math.Cos(phiA)*math.Sin(lamA) + b*math.Cos(phiB)*math.Sin(lamB)
+		z := a*math.Sin(phiA) + b*math.Sin(phiB)
+
+		phi := math.Atan2(z, math.Sqrt(x*x+y*y))
+		lam := math.Atan2(y, x)
+
+		ret = append(ret, [2]float64{phi / DEG_TO_RAD, lam / DEG_TO_RAD})
+	}
+
+	return ret
+}
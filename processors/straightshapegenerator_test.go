@@ -0,0 +1,135 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestStraightShapeGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	// occupy the ID the shape of STBA would get
+	feed.Shapes["straight::STBA"] = &gtfs.Shape{Id: "straight::STBA"}
+
+	// a copy of STBA, which has to share its shape
+	stba := feed.Trips["STBA"]
+	cp := *stba
+	cp.Id = "STBA_COPY"
+	cp.StopTimes = append(gtfs.StopTimes(nil), stba.StopTimes...)
+	feed.Trips[cp.Id] = &cp
+
+	// a trip whose stops are all at the same position
+	same := &gtfs.Trip{Id: "SAME", Route: stba.Route, Service: stba.Service, StopTimes: make(gtfs.StopTimes, 2)}
+	same.StopTimes[0].SetStop(feed.Stops["STAGECOACH"])
+	same.StopTimes[1].SetStop(feed.Stops["STAGECOACH"])
+	feed.Trips[same.Id] = same
+
+	aShp := feed.Trips["AB1"].Shape
+
+	proc := StraightShapeGenerator{}
+	proc.Run(feed)
+
+	if feed.Trips["AB1"].Shape != aShp {
+		t.Error("Existing shapes must not be replaced")
+	}
+
+	if same.Shape != nil {
+		t.Error("No shape should be generated if all stops are at the same position")
+	}
+
+	shp := stba.Shape
+	if shp == nil || shp.Id != "straight1::STBA" {
+		t.Error("Expected shape straight1::STBA for trip STBA")
+		return
+	}
+
+	if cp.Shape != shp {
+		t.Error("Trips with the same stop sequence should share their shape")
+	}
+
+	if len(shp.Points) != 2 {
+		t.Errorf("Expected 2 shape points, got %d", len(shp.Points))
+		return
+	}
+
+	latA, lonA := getStopLatLon(feed.Stops["STAGECOACH"])
+	latB, lonB := getStopLatLon(feed.Stops["BEATTY_AIRPORT"])
+	d := haversine(float64(latA), float64(lonA), float64(latB), float64(lonB))
+
+	if !FloatEquals(shp.Points[1].Dist_traveled, float32(d), 0.01) || !FloatEquals(stba.StopTimes[1].Shape_dist_traveled(), float32(d), 0.01) {
+		t.Error(shp.Points[1].Dist_traveled, stba.StopTimes[1].Shape_dist_traveled(), d)
+	}
+
+	if stba.StopTimes[0].Shape_dist_traveled() != 0 {
+		t.Error(stba.StopTimes[0].Shape_dist_traveled())
+	}
+
+	// CITY1 and CITY2 serve the same stops in opposite order
+	if feed.Trips["CITY1"].Shape == nil || feed.Trips["CITY1"].Shape == feed.Trips["CITY2"].Shape {
+		t.Error("CITY1 and CITY2 should have different shapes")
+	}
+}
+
+func TestStraightShapeGeneratorDensify(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	proc := StraightShapeGenerator{MaxSegLen: 1000}
+	proc.Run(feed)
+
+	stba := feed.Trips["STBA"]
+	shp := stba.Shape
+	latA, lonA := getStopLatLon(feed.Stops["STAGECOACH"])
+	latB, lonB := getStopLatLon(feed.Stops["BEATTY_AIRPORT"])
+	d := haversine(float64(latA), float64(lonA), float64(latB), float64(lonB))
+
+	// ~6 km between the two stops
+	if len(shp.Points) != 8 {
+		t.Errorf("Expected 8 shape points, got %d", len(shp.Points))
+		return
+	}
+
+	for i := 1; i < len(shp.Points); i++ {
+		a := shp.Points[i-1]
+		b := shp.Points[i]
+		seg := haversine(float64(a.Lat), float64(a.Lon), float64(b.Lat), float64(b.Lon))
+		if seg > 1000.1 {
+			t.Errorf("Segment %d is %f meters long", i, seg)
+		}
+		if b.Dist_traveled <= a.Dist_traveled || b.Sequence != a.Sequence+1 {
+			t.Error("Shape points are not measured or ordered correctly")
+		}
+	}
+
+	if last := shp.Points[len(shp.Points)-1]; last.Lat != latB || last.Lon != lonB {
+		t.Error("Shape should end at the last stop")
+	}
+
+	if !FloatEquals(stba.StopTimes[1].Shape_dist_traveled(), float32(d), 1) {
+		t.Error(stba.StopTimes[1].Shape_dist_traveled(), d)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// TransferGenerator generates transfers (transfer_type=2) between nearby
+// stops served by different routes. The min_transfer_time is estimated
+// from the walking distance, for stops in the same station it is at least
+// StationTransferTime. Stop pairs for which explicit transfers already
+// exist (also on station level) are never touched.
+type TransferGenerator struct {
+	MaxDist             float64 // in meters
+	WalkingSpeed        float64 // in m/s
+	StationTransferTime int     // in seconds
+}
+
+// Run this TransferGenerator on some feed
+func (tg TransferGenerator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Generating transfers... ")
+
+	bef := len(feed.Transfers)
+
+	// collect routes serving each stop
+	routes := make(map[*gtfs.Stop]map[*gtfs.Route]bool)
+	for _, t := range feed.Trips {
+		for _, st := range t.StopTimes {
+			if _, ok := routes[st.Stop()]; !ok {
+				routes[st.Stop()] = make(map[*gtfs.Route]bool)
+			}
+			routes[st.Stop()][t.Route] = true
+		}
+	}
+
+	// collect stop pairs with explicit transfers
+	explicit := make(map[*gtfs.Stop]map[*gtfs.Stop]bool)
+	for tk := range feed.Transfers {
+		if tk.From_stop == nil || tk.To_stop == nil {
+			continue
+		}
+		if _, ok := explicit[tk.From_stop]; !ok {
+			explicit[tk.From_stop] = make(map[*gtfs.Stop]bool)
+		}
+		explicit[tk.From_stop][tk.To_stop] = true
+	}
+
+	stops := make([]*gtfs.Stop, 0, len(routes))
+	clusters := make([]*StopCluster, 0, len(routes))
+	for s := range routes {
+		stops = append(stops, s)
+		clusters = append(clusters, NewStopCluster(s))
+	}
+
+	idx := NewStopClusterIdx(clusters, tg.MaxDist*2, tg.MaxDist*2)
+
+	for _, a := range stops {
+		lat, lon := getStopLatLon(a)
+
+		// distances in web mercator are stretched by 1/cos(lat)
+		mercDist := tg.MaxDist / math.Max(0.01, math.Cos(float64(lat)*DEG_TO_RAD))
+
+		for cid := range idx.GetNeighborsByLatLon(float64(lat), float64(lon), mercDist) {
+			b := stops[cid]
+
+			if a == b || !tg.hasNewRoutes(routes[a], routes[b]) || tg.hasExplicit(explicit, a, b) {
+				continue
+			}
+
+			latB, lonB := getStopLatLon(b)
+			d := haversine(float64(lat), float64(lon), float64(latB), float64(lonB))
+
+			if d > tg.MaxDist {
+				continue
+			}
+
+			t := int(math.Ceil(d / tg.WalkingSpeed))
+
+			if a.Parent_station != nil && a.Parent_station == b.Parent_station {
+				t = imax(t, tg.StationTransferTime)
+			}
+
+			feed.Transfers[gtfs.TransferKey{From_stop: a, To_stop: b}] = gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: t}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (+%d transfers)\n", len(feed.Transfers)-bef)
+}
+
+// True if b is served by a route which does not serve a
+func (tg TransferGenerator) hasNewRoutes(a map[*gtfs.Route]bool, b map[*gtfs.Route]bool) bool {
+	for r := range b {
+		if !a[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// True if an explicit transfer exists between a and b, or their parents
+func (tg TransferGenerator) hasExplicit(explicit map[*gtfs.Stop]map[*gtfs.Stop]bool, a *gtfs.Stop, b *gtfs.Stop) bool {
+	for _, from := range []*gtfs.Stop{a, a.Parent_station} {
+		if from == nil {
+			continue
+		}
+		for _, to := range []*gtfs.Stop{b, b.Parent_station} {
+			if to != nil && explicit[from][to] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestTransferGenerator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	explicit := gtfs.TransferKey{From_stop: feed.Stops["EMSI"], To_stop: feed.Stops["STAGECOACH"]}
+	feed.Transfers[explicit] = gtfs.TransferVal{Transfer_type: 3, Min_transfer_time: -1}
+
+	proc := TransferGenerator{MaxDist: 3000, WalkingSpeed: 1.2, StationTransferTime: 120}
+	proc.Run(feed)
+
+	if v, ok := feed.Transfers[explicit]; !ok || v.Transfer_type != 3 || v.Min_transfer_time != -1 {
+		t.Error("Explicit transfer was overridden")
+	}
+
+	v, ok := feed.Transfers[gtfs.TransferKey{From_stop: feed.Stops["DADAN"], To_stop: feed.Stops["STAGECOACH"]}]
+
+	if !ok {
+		t.Error("Expected transfer from DADAN to STAGECOACH")
+		return
+	}
+
+	if v.Transfer_type != 2 || v.Min_transfer_time <= 0 {
+		t.Error("Expected transfer_type 2 with positive min_transfer_time")
+	}
+
+	for tk := range feed.Transfers {
+		if tk.From_stop == tk.To_stop {
+			t.Error("Unexpected self-transfer at " + tk.From_stop.Id)
+		}
+	}
+}
+
+func TestTransferGeneratorGridBorder(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+
+	// two stops 70 meters apart, both in the first cell of the grid
+	a := &gtfs.Stop{Id: "a", Lat: 50, Lon: 8}
+	b := &gtfs.Stop{Id: "b", Lat: 50, Lon: 8.00098}
+	feed.Stops[a.Id] = a
+	feed.Stops[b.Id] = b
+
+	for _, s := range []*gtfs.Stop{a, b} {
+		r := &gtfs.Route{Id: "r" + s.Id}
+		trip := &gtfs.Trip{Id: "t" + s.Id, Route: r, StopTimes: make(gtfs.StopTimes, 1)}
+		trip.StopTimes[0].SetStop(s)
+		feed.Routes[r.Id] = r
+		feed.Trips[trip.Id] = trip
+	}
+
+	proc := TransferGenerator{MaxDist: 200, WalkingSpeed: 1.2, StationTransferTime: 120}
+	proc.Run(feed)
+
+	if len(feed.Transfers) != 2 {
+		t.Errorf("Expected 2 transfers, got %d", len(feed.Transfers))
+	}
+
+	if _, ok := feed.Transfers[gtfs.TransferKey{From_stop: a, To_stop: b}]; !ok {
+		t.Error("Expected transfer from a to b")
+	}
+
+	if _, ok := feed.Transfers[gtfs.TransferKey{From_stop: b, To_stop: a}]; !ok {
+		t.Error("Expected transfer from b to a")
+	}
+}
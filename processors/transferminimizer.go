@@ -0,0 +1,193 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// TransferMinimizer canonicalizes transfers, which is especially useful
+// after stops have been merged. Transfers colliding on the same key
+// are merged during stop merging (the more restrictive transfer_type wins,
+// for equal types the higher min_transfer_time, see mergeTransferVal).
+// Afterwards, stop-level transfers between the children of two stations are
+// lifted to station level if all children agree, and child-level transfers
+// which equal the rule that would apply without them are removed.
+type TransferMinimizer struct {
+}
+
+// Run this TransferMinimizer on some feed
+func (tm TransferMinimizer) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Minimizing transfers... ")
+
+	bef := len(feed.Transfers)
+
+	childs := make(map[*gtfs.Stop][]*gtfs.Stop)
+	for _, s := range feed.Stops {
+		if s.Parent_station != nil && s.Location_type == 0 {
+			childs[s.Parent_station] = append(childs[s.Parent_station], s)
+		}
+	}
+
+	tm.liftTransfers(feed, childs)
+	tm.removeRedundant(feed)
+
+	fmt.Fprintf(os.Stdout, "done. (-%d transfers [-%.2f%%])\n",
+		bef-len(feed.Transfers),
+		100.0*float64(bef-len(feed.Transfers))/(float64(bef)+0.001))
+}
+
+// Lift unscoped transfers between the platforms of two stations to station
+// level, if every platform pair has a transfer with the same value. Transfers
+// within a single station are never lifted, as a station-level rule would
+// also cover transfers from a platform to itself.
+func (tm TransferMinimizer) liftTransfers(feed *gtfsparser.Feed, childs map[*gtfs.Stop][]*gtfs.Stop) {
+	groups := make(map[[2]*gtfs.Stop]bool)
+
+	for tk := range feed.Transfers {
+		if !tm.isPlain(tk) || tk.From_stop.Parent_station == nil || tk.To_stop.Parent_station == nil {
+			continue
+		}
+		if tk.From_stop.Location_type != 0 || tk.To_stop.Location_type != 0 {
+			continue
+		}
+		groups[[2]*gtfs.Stop{tk.From_stop.Parent_station, tk.To_stop.Parent_station}] = true
+	}
+
+	for g := range groups {
+		from := g[0]
+		to := g[1]
+
+		if from == to {
+			continue
+		}
+
+		val, ok := tm.commonVal(feed, childs, from, to)
+		if !ok {
+			continue
+		}
+
+		parentKey := gtfs.TransferKey{From_stop: from, To_stop: to}
+		if existing, ok := feed.Transfers[parentKey]; ok && existing != val {
+			continue
+		}
+
+		feed.Transfers[parentKey] = val
+
+		for _, a := range childs[from] {
+			for _, b := range childs[to] {
+				tk := gtfs.TransferKey{From_stop: a, To_stop: b}
+				if _, ok := feed.Transfers[tk]; ok {
+					feed.DeleteTransfer(tk)
+				}
+			}
+		}
+	}
+}
+
+// Return the value shared by all unscoped transfers between the platforms
+// of from and to, and false if there is no such common value
+func (tm TransferMinimizer) commonVal(feed *gtfsparser.Feed, childs map[*gtfs.Stop][]*gtfs.Stop, from *gtfs.Stop, to *gtfs.Stop) (gtfs.TransferVal, bool) {
+	var val gtfs.TransferVal
+	found := false
+
+	for _, a := range childs[from] {
+		// a station-to-platform rule would take precedence after lifting
+		if _, ok := feed.Transfers[gtfs.TransferKey{From_stop: a, To_stop: to}]; ok {
+			return val, false
+		}
+
+		for _, b := range childs[to] {
+			if _, ok := feed.Transfers[gtfs.TransferKey{From_stop: from, To_stop: b}]; ok {
+				return val, false
+			}
+
+			tv, ok := feed.Transfers[gtfs.TransferKey{From_stop: a, To_stop: b}]
+			if !ok {
+				return val, false
+			}
+
+			if found && tv != val {
+				return val, false
+			}
+
+			val = tv
+			found = true
+		}
+	}
+
+	return val, found
+}
+
+// Remove transfers which equal the rule that would apply without them
+func (tm TransferMinimizer) removeRedundant(feed *gtfsparser.Feed) {
+	for tk, tv := range feed.Transfers {
+		if tk.From_stop == nil || tk.To_stop == nil {
+			continue
+		}
+
+		fromP := tk.From_stop.Parent_station
+		toP := tk.To_stop.Parent_station
+
+		if fromP == nil && toP == nil {
+			continue
+		}
+
+		// the half-lifted rules are more specific than the station-level rule
+		fallbacks := make([]gtfs.TransferKey, 0, 2)
+
+		if fromP != nil {
+			k := tk
+			k.From_stop = fromP
+			if _, ok := feed.Transfers[k]; ok {
+				fallbacks = append(fallbacks, k)
+			}
+		}
+
+		if toP != nil {
+			k := tk
+			k.To_stop = toP
+			if _, ok := feed.Transfers[k]; ok {
+				fallbacks = append(fallbacks, k)
+			}
+		}
+
+		if len(fallbacks) == 0 && fromP != nil && toP != nil {
+			k := tk
+			k.From_stop = fromP
+			k.To_stop = toP
+			if _, ok := feed.Transfers[k]; ok {
+				fallbacks = append(fallbacks, k)
+			}
+		}
+
+		if len(fallbacks) == 0 {
+			continue
+		}
+
+		redundant := true
+		for _, k := range fallbacks {
+			if feed.Transfers[k] != tv {
+				redundant = false
+				break
+			}
+		}
+
+		if redundant {
+			feed.DeleteTransfer(tk)
+		}
+	}
+}
+
+// True if a transfer is only scoped by its stops
+func (tm TransferMinimizer) isPlain(tk gtfs.TransferKey) bool {
+	return tk.From_stop != nil && tk.To_stop != nil && tk.From_route == nil && tk.To_route == nil && tk.From_trip == nil && tk.To_trip == nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func TestTransferMinimizer(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+
+	e := feed.Parse("./testfeed")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	f12 := feed.Stops["F12"]
+	f12s := feed.Stops["F12S"]
+	f12n := feed.Stops["F12N"]
+	bb := feed.Stops["duplicateBB"]
+	plat := feed.Stops["hasduplicateasparent"]
+
+	between := gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: 60}
+	within := gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: 120}
+	self := gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: 120}
+
+	// all platforms of F12 to the single platform of duplicateBB
+	feed.Transfers[gtfs.TransferKey{From_stop: f12s, To_stop: plat}] = between
+	feed.Transfers[gtfs.TransferKey{From_stop: f12n, To_stop: plat}] = between
+
+	// between the platforms of F12, and a self-transfer at F12S only
+	feed.Transfers[gtfs.TransferKey{From_stop: f12s, To_stop: f12n}] = within
+	feed.Transfers[gtfs.TransferKey{From_stop: f12n, To_stop: f12s}] = within
+	feed.Transfers[gtfs.TransferKey{From_stop: f12s, To_stop: f12s}] = self
+
+	proc := TransferMinimizer{}
+	proc.Run(feed)
+
+	if v, ok := feed.Transfers[gtfs.TransferKey{From_stop: f12, To_stop: bb}]; !ok || v != between {
+		t.Error("Expected transfer from F12 to duplicateBB")
+	}
+
+	// F12N has no self-transfer, a station-level rule would add one
+	if _, ok := feed.Transfers[gtfs.TransferKey{From_stop: f12, To_stop: f12}]; ok {
+		t.Error("Transfers within F12 must not be lifted")
+	}
+
+	for _, tk := range []gtfs.TransferKey{
+		{From_stop: f12s, To_stop: f12n},
+		{From_stop: f12n, To_stop: f12s},
+	} {
+		if v, ok := feed.Transfers[tk]; !ok || v != within {
+			t.Errorf("Expected transfer from %s to %s", tk.From_stop.Id, tk.To_stop.Id)
+		}
+	}
+
+	if v, ok := feed.Transfers[gtfs.TransferKey{From_stop: f12s, To_stop: f12s}]; !ok || v != self {
+		t.Error("Self-transfer at F12S must not be lifted or removed")
+	}
+
+	for _, tk := range []gtfs.TransferKey{
+		{From_stop: f12s, To_stop: plat},
+		{From_stop: f12n, To_stop: plat},
+	} {
+		if _, ok := feed.Transfers[tk]; ok {
+			t.Errorf("Transfer from %s to %s should have been removed", tk.From_stop.Id, tk.To_stop.Id)
+		}
+	}
+
+	if len(feed.Transfers) != 4 {
+		t.Errorf("Expected 4 transfers, got %d", len(feed.Transfers))
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// restrictiveness of transfer types, used to merge conflicting transfers:
+// not possible (3) > in-seat not allowed (5) > min. time (2) > timed (1) >
+// in-seat (4) > recommended (0)
+var transferTypeRank = map[int]int{0: 0, 4: 1, 1: 2, 2: 3, 5: 4, 3: 5}
+
+// Merge two conflicting transfer values for the same key. The more
+// restrictive transfer type is kept, and of two equally restrictive
+// transfers, the one with the higher explicit min_transfer_time.
+func mergeTransferVal(a gtfs.TransferVal, b gtfs.TransferVal) gtfs.TransferVal {
+	if transferTypeRank[a.Transfer_type] != transferTypeRank[b.Transfer_type] {
+		if transferTypeRank[a.Transfer_type] > transferTypeRank[b.Transfer_type] {
+			return a
+		}
+		return b
+	}
+
+	if b.Min_transfer_time > a.Min_transfer_time {
+		return b
+	}
+
+	return a
+}
+
+// Move the transfer tk to the key tkNew, together with its additional
+// fields. If a transfer with key tkNew already exists, both are merged
+// using mergeTransferVal and tk is dropped. Returns true if the transfer
+// was moved to a previously unused key.
+func moveTransfer(feed *gtfsparser.Feed, tk gtfs.TransferKey, tkNew gtfs.TransferKey) bool {
+	tv, ok := feed.Transfers[tk]
+	if !ok || tk == tkNew {
+		return false
+	}
+
+	if existing, ok := feed.Transfers[tkNew]; ok {
+		feed.Transfers[tkNew] = mergeTransferVal(existing, tv)
+		feed.DeleteTransfer(tk)
+		return false
+	}
+
+	feed.Transfers[tkNew] = tv
+
+	for k := range feed.TransfersAddFlds {
+		if v, ok := feed.TransfersAddFlds[k][tk]; ok {
+			feed.TransfersAddFlds[k][tkNew] = v
+		}
+	}
+
+	feed.DeleteTransfer(tk)
+
+	return true
+}
+
+// Collect the transfers referencing each trip
+func getTripTransfers(feed *gtfsparser.Feed) map[*gtfs.Trip][]gtfs.TransferKey {
+	ret := make(map[*gtfs.Trip][]gtfs.TransferKey)
+
+	for tk := range feed.Transfers {
+		if tk.From_trip != nil {
+			ret[tk.From_trip] = append(ret[tk.From_trip], tk)
+		}
+		if tk.To_trip != nil && tk.To_trip != tk.From_trip {
+			ret[tk.To_trip] = append(ret[tk.To_trip], tk)
+		}
+	}
+
+	return ret
+}
+
+// Collect the transfers referencing each route
+func getRouteTransfers(feed *gtfsparser.Feed) map[*gtfs.Route][]gtfs.TransferKey {
+	ret := make(map[*gtfs.Route][]gtfs.TransferKey)
+
+	for tk := range feed.Transfers {
+		if tk.From_route != nil {
+			ret[tk.From_route] = append(ret[tk.From_route], tk)
+		}
+		if tk.To_route != nil && tk.To_route != tk.From_route {
+			ret[tk.To_route] = append(ret[tk.To_route], tk)
+		}
+	}
+
+	return ret
+}
+
+// Redirect all transfers scoped to trip t to trip ref. Transfers which
+// would then lead from ref to ref are dropped.
+func redirectTripTransfers(feed *gtfsparser.Feed, idx map[*gtfs.Trip][]gtfs.TransferKey, t *gtfs.Trip, ref *gtfs.Trip) {
+	for _, tk := range idx[t] {
+		if _, ok := feed.Transfers[tk]; !ok {
+			// already moved or deleted
+			continue
+		}
+
+		tkNew := tk
+		if tkNew.From_trip == t {
+			tkNew.From_trip = ref
+		}
+		if tkNew.To_trip == t {
+			tkNew.To_trip = ref
+		}
+
+		if tkNew.From_trip == tkNew.To_trip {
+			feed.DeleteTransfer(tk)
+			continue
+		}
+
+		if moveTransfer(feed, tk, tkNew) {
+			if tkNew.From_trip != nil {
+				idx[tkNew.From_trip] = append(idx[tkNew.From_trip], tkNew)
+			}
+			if tkNew.To_trip != nil && tkNew.To_trip != tkNew.From_trip {
+				idx[tkNew.To_trip] = append(idx[tkNew.To_trip], tkNew)
+			}
+		}
+	}
+
+	delete(idx, t)
+}
+
+// Redirect all transfers scoped to route r to route ref
+func redirectRouteTransfers(feed *gtfsparser.Feed, idx map[*gtfs.Route][]gtfs.TransferKey, r *gtfs.Route, ref *gtfs.Route) {
+	for _, tk := range idx[r] {
+		if _, ok := feed.Transfers[tk]; !ok {
+			// already moved or deleted
+			continue
+		}
+
+		tkNew := tk
+		if tkNew.From_route == r {
+			tkNew.From_route = ref
+		}
+		if tkNew.To_route == r {
+			tkNew.To_route = ref
+		}
+
+		if moveTransfer(feed, tk, tkNew) {
+			if tkNew.From_route != nil {
+				idx[tkNew.From_route] = append(idx[tkNew.From_route], tkNew)
+			}
+			if tkNew.To_route != nil && tkNew.To_route != tkNew.From_route {
+				idx[tkNew.To_route] = append(idx[tkNew.To_route], tkNew)
+			}
+		}
+	}
+
+	delete(idx, r)
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// Return the union of the translations ref and b. Translations of b for a
+// field and language ref already has a translation for are dropped.
+func mergeTranslations(ref []*gtfs.Translation, b []*gtfs.Translation) []*gtfs.Translation {
+	has := make(map[[2]string]bool, len(ref))
+	for _, tr := range ref {
+		has[[2]string{tr.FieldName, tr.Language.GetLangString()}] = true
+	}
+
+	for _, tr := range b {
+		key := [2]string{tr.FieldName, tr.Language.GetLangString()}
+		if has[key] {
+			continue
+		}
+		has[key] = true
+		ref = append(ref, tr)
+	}
+
+	return ref
+}
+
+// Merge the translations of trip t into trip ref
+func mergeTripTranslations(ref *gtfs.Trip, t *gtfs.Trip) {
+	if t.Translations == nil || len(*t.Translations) == 0 {
+		return
+	}
+
+	if ref.Translations == nil {
+		sl := make([]*gtfs.Translation, 0)
+		ref.Translations = &sl
+	}
+
+	*ref.Translations = mergeTranslations(*ref.Translations, *t.Translations)
+}
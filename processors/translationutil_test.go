@@ -0,0 +1,66 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs"
+	"testing"
+)
+
+func newTestTranslation(t *testing.T, field string, lang string, val string) *gtfs.Translation {
+	l, err := gtfs.NewLanguageISO6391(lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gtfs.Translation{FieldName: field, Language: l, Translation: val}
+}
+
+func TestMergeTranslations(t *testing.T) {
+	deName := newTestTranslation(t, "stop_name", "de", "Bahnhof")
+	frName := newTestTranslation(t, "stop_name", "fr", "Gare")
+	deDesc := newTestTranslation(t, "stop_desc", "de", "Beschreibung")
+
+	ref := []*gtfs.Translation{deName}
+	b := []*gtfs.Translation{newTestTranslation(t, "stop_name", "de", "Hauptbahnhof"), frName, deDesc, newTestTranslation(t, "stop_name", "fr", "Gare centrale")}
+
+	ret := mergeTranslations(ref, b)
+
+	if len(ret) != 3 {
+		t.Errorf("Expected 3 translations, got %d", len(ret))
+		return
+	}
+
+	if ret[0] != deName || ret[1] != frName || ret[2] != deDesc {
+		t.Error("Translations of ref must take precedence, the first translation of b must win")
+	}
+
+	// merging into an empty list removes duplicates
+	if ret := mergeTranslations(nil, []*gtfs.Translation{deName, deName, frName}); len(ret) != 2 {
+		t.Errorf("Expected 2 translations, got %d", len(ret))
+	}
+}
+
+func TestMergeTripTranslations(t *testing.T) {
+	a := &gtfs.Trip{Id: "a"}
+	b := &gtfs.Trip{Id: "b"}
+
+	trs := []*gtfs.Translation{newTestTranslation(t, "trip_headsign", "de", "Bahnhof")}
+	b.Translations = &trs
+
+	mergeTripTranslations(a, b)
+
+	if a.Translations == nil || len(*a.Translations) != 1 || (*a.Translations)[0] != trs[0] {
+		t.Error("Translations of b should have been merged into a")
+	}
+
+	// the translations of b must not be shared with a
+	*a.Translations = append(*a.Translations, newTestTranslation(t, "trip_headsign", "fr", "Gare"))
+
+	if len(*b.Translations) != 1 {
+		t.Error("Translations of b were modified")
+	}
+}
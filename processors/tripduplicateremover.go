@@ -28,6 +28,7 @@ type TripDuplicateRemover struct {
 	serviceList map[*gtfs.Service][]uint64
 	refDate     time.Time
 	serviceRefs map[*gtfs.Service]int
+	transfers   map[*gtfs.Trip][]gtfs.TransferKey
 }
 
 type Overlap struct {
@@ -77,6 +78,7 @@ func (m TripDuplicateRemover) Run(feed *gtfsparser.Feed) {
 	}
 
 	m.serviceList = make(map[*gtfs.Service][]uint64)
+	m.transfers = getTripTransfers(feed)
 
 	// infinity time
 	m.refDate = time.Unix(1<<63-62135596801, 999999999)
@@ -183,15 +185,7 @@ func (m *TripDuplicateRemover) combineAdjTrips(feed *gtfsparser.Feed, ref *gtfs.
 			}
 		}
 
-		if t.Attributions != nil {
-			if ref.Attributions == nil {
-				sl := make([]*gtfs.Attribution, 0)
-				ref.Attributions = &sl
-			}
-			for _, attr := range *t.Attributions {
-				*ref.Attributions = append(*ref.Attributions, attr)
-			}
-		}
+		mergeTripAttributions(ref, t)
 
 		for fld, v := range feed.TripsAddFlds {
 			valT, okT := v[t.Id]
@@ -201,6 +195,9 @@ func (m *TripDuplicateRemover) combineAdjTrips(feed *gtfsparser.Feed, ref *gtfs.
 			}
 		}
 
+		mergeTripTranslations(ref, t)
+		redirectTripTransfers(feed, m.transfers, t, ref)
+
 		feed.DeleteTrip(t.Id)
 		m.serviceRefs[t.Service]--
 	}
@@ -222,15 +219,10 @@ func (m *TripDuplicateRemover) combineContTrips(feed *gtfsparser.Feed, ref *gtfs
 			}
 		}
 
-		if t.Attributions != nil {
-			if ref.Attributions == nil {
-				sl := make([]*gtfs.Attribution, 0)
-				ref.Attributions = &sl
-			}
-			for _, attr := range *t.Attributions {
-				*ref.Attributions = append(*ref.Attributions, attr)
-			}
-		}
+		mergeTripAttributions(ref, t)
+
+		mergeTripTranslations(ref, t)
+		redirectTripTransfers(feed, m.transfers, t, ref)
 
 		feed.DeleteTrip(t.Id)
 		m.serviceRefs[t.Service]--
@@ -244,15 +236,7 @@ func (m *TripDuplicateRemover) combineEqTrips(feed *gtfsparser.Feed, ref *gtfs.T
 			continue
 		}
 
-		if t.Attributions != nil {
-			if ref.Attributions == nil {
-				sl := make([]*gtfs.Attribution, 0)
-				ref.Attributions = &sl
-			}
-			for _, attr := range *t.Attributions {
-				*ref.Attributions = append(*ref.Attributions, attr)
-			}
-		}
+		mergeTripAttributions(ref, t)
 
 		if ref.Bikes_allowed == 0 && t.Bikes_allowed > 0 {
 			ref.Bikes_allowed = t.Bikes_allowed
@@ -287,6 +271,9 @@ func (m *TripDuplicateRemover) combineEqTrips(feed *gtfsparser.Feed, ref *gtfs.T
 			ref.Short_name = t.Short_name
 		}
 
+		mergeTripTranslations(ref, t)
+		redirectTripTransfers(feed, m.transfers, t, ref)
+
 		feed.DeleteTrip(t.Id)
 		m.serviceRefs[t.Service]--
 	}
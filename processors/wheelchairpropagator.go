@@ -0,0 +1,213 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// WheelchairBoardingPropagator makes the wheelchair_boarding value children
+// inherit from their parents explicit, and checks wheelchair information for
+// contradictions. Children overriding the value of their parent are allowed
+// by GTFS, and are only reported as information. Optionally, the findings and
+// per-route accessibility statistics are written to CSV files.
+type WheelchairBoardingPropagator struct {
+	ReportFile     string
+	RouteStatsFile string
+}
+
+// A single wheelchair accessibility finding
+type wheelchairFinding struct {
+	severity string
+	entity   string
+	id       string
+	value    int8
+	refId    string
+	ref      int8
+	issue    string
+}
+
+// Run this WheelchairBoardingPropagator on some feed
+func (wp WheelchairBoardingPropagator) Run(feed *gtfsparser.Feed) {
+	fmt.Fprintf(os.Stdout, "Propagating wheelchair boarding information... ")
+
+	findings := make([]wheelchairFinding, 0)
+	changed := 0
+	overrides := 0
+	contras := 0
+
+	// sort stops by depth, so that parents are always handled first
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops))
+	for _, s := range feed.Stops {
+		stops = append(stops, s)
+	}
+
+	depths := make(map[*gtfs.Stop]int, len(stops))
+	for _, s := range stops {
+		depths[s] = wp.depth(s)
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		if depths[stops[i]] != depths[stops[j]] {
+			return depths[stops[i]] < depths[stops[j]]
+		}
+		return stops[i].Id < stops[j].Id
+	})
+
+	for _, s := range stops {
+		if s.Parent_station == nil || s.Parent_station.Wheelchair_boarding == 0 {
+			continue
+		}
+
+		if s.Wheelchair_boarding == 0 {
+			s.Wheelchair_boarding = s.Parent_station.Wheelchair_boarding
+			changed++
+		} else if s.Wheelchair_boarding != s.Parent_station.Wheelchair_boarding {
+			findings = append(findings, wheelchairFinding{"info", "stop", s.Id, s.Wheelchair_boarding, s.Parent_station.Id, s.Parent_station.Wheelchair_boarding, "stop overrides value of parent station"})
+			overrides++
+		}
+	}
+
+	for _, t := range feed.Trips {
+		if t.Wheelchair_accessible != 1 {
+			continue
+		}
+
+		for _, st := range t.StopTimes {
+			if st.Stop().Wheelchair_boarding == 2 {
+				findings = append(findings, wheelchairFinding{"error", "trip", t.Id, t.Wheelchair_accessible, st.Stop().Id, st.Stop().Wheelchair_boarding, "accessible trip serves inaccessible stop"})
+				contras++
+			}
+		}
+	}
+
+	if len(wp.ReportFile) > 0 {
+		if err := wp.writeReport(findings); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write wheelchair report to %s: %s\n", wp.ReportFile, err.Error())
+		}
+	}
+
+	if len(wp.RouteStatsFile) > 0 {
+		if err := wp.writeRouteStats(feed); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write wheelchair route statistics to %s: %s\n", wp.RouteStatsFile, err.Error())
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "done. (%d stops changed [%.2f%%], %d contradictions found, %d stops override their parent)\n",
+		changed,
+		100.0*float64(changed)/(float64(len(feed.Stops))+0.001),
+		contras,
+		overrides)
+}
+
+// Return the number of ancestors of a stop
+func (wp WheelchairBoardingPropagator) depth(s *gtfs.Stop) int {
+	d := 0
+	for p := s.Parent_station; p != nil && d < 3; p = p.Parent_station {
+		d++
+	}
+	return d
+}
+
+func (wp WheelchairBoardingPropagator) writeReport(findings []wheelchairFinding) error {
+	f, err := os.Create(wp.ReportFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"severity", "entity", "id", "value", "ref_stop_id", "ref_value", "issue"})
+
+	for _, c := range findings {
+		w.Write([]string{c.severity, c.entity, c.id, strconv.Itoa(int(c.value)), c.refId, strconv.Itoa(int(c.ref)), c.issue})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func (wp WheelchairBoardingPropagator) writeRouteStats(feed *gtfsparser.Feed) error {
+	f, err := os.Create(wp.RouteStatsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// trip counts and stop visit counts per accessibility value
+	trips := make(map[*gtfs.Route]*[3]int)
+	visits := make(map[*gtfs.Route]*[3]int)
+	full := make(map[*gtfs.Route]int)
+
+	for _, t := range feed.Trips {
+		if _, ok := trips[t.Route]; !ok {
+			trips[t.Route] = &[3]int{}
+			visits[t.Route] = &[3]int{}
+		}
+
+		if t.Wheelchair_accessible >= 0 && t.Wheelchair_accessible <= 2 {
+			trips[t.Route][t.Wheelchair_accessible]++
+		}
+
+		allAccessible := t.Wheelchair_accessible == 1
+
+		for _, st := range t.StopTimes {
+			wb := st.Stop().Wheelchair_boarding
+			if wb >= 0 && wb <= 2 {
+				visits[t.Route][wb]++
+			}
+			if wb != 1 {
+				allAccessible = false
+			}
+		}
+
+		if allAccessible {
+			full[t.Route]++
+		}
+	}
+
+	routes := make([]*gtfs.Route, 0, len(trips))
+	for r := range trips {
+		routes = append(routes, r)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Id < routes[j].Id
+	})
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"route_id", "route_short_name", "route_long_name", "trips", "trips_accessible", "trips_not_accessible", "trips_unknown", "trips_fully_accessible", "stop_visits", "stop_visits_accessible", "stop_visits_not_accessible", "stop_visits_unknown"})
+
+	for _, r := range routes {
+		t := trips[r]
+		v := visits[r]
+		w.Write([]string{
+			r.Id,
+			r.Short_name,
+			r.Long_name,
+			strconv.Itoa(t[0] + t[1] + t[2]),
+			strconv.Itoa(t[1]),
+			strconv.Itoa(t[2]),
+			strconv.Itoa(t[0]),
+			strconv.Itoa(full[r]),
+			strconv.Itoa(v[0] + v[1] + v[2]),
+			strconv.Itoa(v[1]),
+			strconv.Itoa(v[2]),
+			strconv.Itoa(v[0]),
+		})
+	}
+
+	w.Flush()
+	return w.Error()
+}
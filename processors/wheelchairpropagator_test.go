@@ -0,0 +1,73 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package processors
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickbr/gtfsparser"
+)
+
+func TestWheelchairBoardingPropagator(t *testing.T) {
+	feed := gtfsparser.NewFeed()
+	opts := gtfsparser.ParseOptions{UseDefValueOnError: false, DropErroneous: false, DryRun: false}
+	feed.SetParseOpts(opts)
+	if err := feed.Parse("./testfeed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	feed.Stops["F12"].Wheelchair_boarding = 1
+	feed.Stops["E1"].Wheelchair_boarding = 2
+	feed.Stops["STAGECOACH"].Wheelchair_boarding = 2
+	feed.Trips["STBA"].Wheelchair_accessible = 1
+
+	path := filepath.Join(t.TempDir(), "wheelchair.csv")
+	WheelchairBoardingPropagator{ReportFile: path}.Run(feed)
+
+	if feed.Stops["E2"].Wheelchair_boarding != 1 {
+		t.Error("E2 should inherit wheelchair_boarding from F12")
+	}
+
+	// boarding areas inherit via their platform
+	if feed.Stops["F12S"].Wheelchair_boarding != 1 || feed.Stops["B1"].Wheelchair_boarding != 1 {
+		t.Error("F12S and B1 should inherit wheelchair_boarding from F12")
+	}
+
+	if feed.Stops["E1"].Wheelchair_boarding != 2 {
+		t.Error("E1 overrides the value of its parent and must be kept")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(rows) != 3 {
+		t.Error(rows)
+		return
+	}
+
+	if rows[1][0] != "info" || rows[1][2] != "E1" || rows[1][4] != "F12" {
+		t.Error(rows[1])
+	}
+
+	if rows[2][0] != "error" || rows[2][2] != "STBA" || rows[2][4] != "STAGECOACH" {
+		t.Error(rows[2])
+	}
+}